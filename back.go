@@ -0,0 +1,109 @@
+package main
+
+import (
+	"image"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/trace"
+)
+
+type BackPattern int
+
+const (
+	BackTheme BackPattern = iota
+	BackLines
+	BackChecks
+	BackLattice
+	BackDots
+)
+
+/* CardBack is a design of card back. Pattern is drawn with Light over Dark inside of white frame. */
+type CardBack struct {
+	Name    string
+	Pattern BackPattern
+	Dark    RGB
+	Light   RGB
+}
+
+/*
+ * CardBacks are put into AtlasBackRow of card atlas, one per column, so there may be no more than AtlasColumns of them.
+ * NOTE(anton2920): back from theme takes the first column. Themes without back have the first generated design there.
+ */
+var CardBacks = [...]CardBack{
+	{Name: "from theme", Pattern: BackTheme},
+	{"blue lines", BackLines, RGB{0, 0, 128}, RGB{0, 128, 255}},
+	{"red lines", BackLines, RGB{128, 0, 0}, RGB{255, 96, 96}},
+	{"blue checks", BackChecks, RGB{0, 0, 128}, RGB{0, 64, 192}},
+	{"green checks", BackChecks, RGB{0, 96, 0}, RGB{0, 160, 64}},
+	{"red lattice", BackLattice, RGB{160, 0, 0}, RGB{255, 192, 192}},
+	{"purple dots", BackDots, RGB{96, 0, 128}, RGB{224, 160, 255}},
+}
+
+var CardBackNames []string
+
+var CardBackOption int
+
+func init() {
+	for i := 0; i < len(CardBacks); i++ {
+		CardBackNames = append(CardBackNames, CardBacks[i].Name)
+	}
+}
+
+func FillRGBA(img *image.RGBA, rect image.Rectangle, c RGB) {
+	rect = rect.Intersect(img.Rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i+0], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, 0xFF
+		}
+	}
+}
+
+/* GenerateBack draws back into img, which has size of a card. Black border and white frame are the same for all designs. */
+func GenerateBack(img *image.RGBA, back *CardBack) {
+	defer trace.End(trace.Begin(""))
+
+	rect := img.Rect
+	width, height := rect.Dx(), rect.Dy()
+	FillRGBA(img, rect, RGB{0, 0, 0})
+	FillRGBA(img, rect.Inset(1), RGB{0xFF, 0xFF, 0xFF})
+
+	inner := rect.Inset(4)
+	FillRGBA(img, inner, back.Dark)
+
+	step := max(width/12, 4)
+	for y := inner.Min.Y; y < inner.Max.Y; y++ {
+		for x := inner.Min.X; x < inner.Max.X; x++ {
+			i, j := x-rect.Min.X, y-rect.Min.Y
+
+			var light bool
+			switch back.Pattern {
+			case BackLines:
+				/* NOTE(anton2920): lines leave a margin of two pixels at the sides, as the classic back does. */
+				light = (j >= 8) && (j < height-8) && ((j-8)%6 == 0) && (i >= 6) && (i < width-6)
+			case BackChecks:
+				light = ((i-4)/step+(j-4)/step)%2 == 0
+			case BackLattice:
+				light = ((i+j)%step == 0) || ((i-j+height*step)%step == 0)
+			case BackDots:
+				light = ((i-4)%step < step/3) && ((j-4)%step < step/3)
+			}
+			if light {
+				FillRGBA(img, image.Rect(x, y, x+1, y+1), back.Light)
+			}
+		}
+	}
+}
+
+/* DrawCardBack draws back chosen in options from card atlas. */
+func DrawCardBack(renderer gui.Renderer, x, y int) {
+	defer trace.End(trace.Begin(""))
+
+	column := CardBackOption
+	if (column == 0) && (CurrentTheme.Back.Width == 0) {
+		column = 1
+	}
+
+	sprites := CardSprites.Pixmap(CardScale)
+	renderer.RenderPixmap(sprites.Sub(column*CardWidth, AtlasBackRow*CardHeight, (column+1)*CardWidth, (AtlasBackRow+1)*CardHeight), x, y)
+}
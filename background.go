@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/color"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* SolveJob solves copy of position taken when job was made, so it may run on other goroutine. It gives up after limit positions or when stop is set. */
+type SolveJob func(stop *atomic.Bool, limit int) Solution
+
+const (
+	/* BackgroundSolveLimit bounds memory background solver uses, since every position it examines is remembered. */
+	BackgroundSolveLimit = 500000
+
+	BackgroundSolveTimeout = 10 * time.Second
+)
+
+/* MenuBarHeight is the height of menu bar which every game draws at the top of the window. */
+const MenuBarHeight = 20
+
+type BackgroundJob struct {
+	Generation int
+	Solve      SolveJob
+	Stop       *atomic.Bool
+}
+
+type BackgroundResult struct {
+	Generation int
+	Solution   Solution
+}
+
+/*
+ * BackgroundSolver keeps solving the latest position of the game on its own goroutine.
+ * Everything except channels and stop flags is owned by the main goroutine, so game is never touched while solver runs.
+ */
+type BackgroundSolver struct {
+	Jobs    chan BackgroundJob
+	Results chan BackgroundResult
+
+	Game       Game
+	Position   []byte
+	Buffer     []byte
+	Stop       *atomic.Bool
+	Generation int
+
+	Searching bool
+	Status    SolveStatus
+}
+
+func NewBackgroundSolver() *BackgroundSolver {
+	solver := &BackgroundSolver{Jobs: make(chan BackgroundJob, 1), Results: make(chan BackgroundResult, 1)}
+	go solver.Run()
+	return solver
+}
+
+/* Run solves jobs one after another. Job which is replaced while it waits is never started. */
+func (solver *BackgroundSolver) Run() {
+	for job := range solver.Jobs {
+		if job.Stop.Load() {
+			continue
+		}
+
+		timer := time.AfterFunc(BackgroundSolveTimeout, func() { job.Stop.Store(true) })
+		solution := job.Solve(job.Stop, BackgroundSolveLimit)
+		timer.Stop()
+
+		/* NOTE(anton2920): main goroutine may have not picked up the previous result, which is stale now. */
+		select {
+		case <-solver.Results:
+		default:
+		}
+		solver.Results <- BackgroundResult{Generation: job.Generation, Solution: solution}
+	}
+}
+
+/* Submit cancels search which is running and starts a new one. */
+func (solver *BackgroundSolver) Submit(solve SolveJob) {
+	if solver.Stop != nil {
+		solver.Stop.Store(true)
+	}
+	select {
+	case <-solver.Jobs:
+	default:
+	}
+
+	solver.Generation++
+	solver.Stop = new(atomic.Bool)
+	solver.Searching = true
+	solver.Status = SolveUnknown
+	solver.Jobs <- BackgroundJob{Generation: solver.Generation, Solve: solve, Stop: solver.Stop}
+}
+
+/* Reset cancels search and forgets position, so that next game is solved from scratch. */
+func (solver *BackgroundSolver) Reset() {
+	if solver.Stop != nil {
+		solver.Stop.Store(true)
+	}
+	solver.Game = nil
+	solver.Position = solver.Position[:0]
+	solver.Searching = false
+	solver.Status = SolveUnknown
+}
+
+/* Update starts new search whenever position of the game changes and picks up result of the current one. It must be called after game is updated. */
+func (solver *BackgroundSolver) Update(game Game) {
+	defer trace.End(trace.Begin(""))
+
+	analysable, ok := game.(Analysable)
+	if (!ok) || (game.Won()) || (game.Dealing()) {
+		solver.Reset()
+		return
+	}
+
+	solver.Buffer = analysable.AppendPosition(solver.Buffer[:0])
+	if (game != solver.Game) || (!bytes.Equal(solver.Buffer, solver.Position)) {
+		solver.Game = game
+		solver.Position, solver.Buffer = solver.Buffer, solver.Position
+		solver.Submit(analysable.SolveJob())
+	}
+
+	select {
+	case result := <-solver.Results:
+		if result.Generation == solver.Generation {
+			solver.Searching = false
+			solver.Status = result.Solution.Status
+		}
+	default:
+	}
+}
+
+/* Draw shows what solver knows about current position at the right end of menu bar. */
+func (solver *BackgroundSolver) Draw(window *gui.Window, renderer gui.Renderer, ui *gui.UI) {
+	defer trace.End(trace.Begin(""))
+
+	if solver.Game == nil {
+		return
+	}
+
+	var text string
+	var clr color.Color
+	switch {
+	case solver.Searching:
+		text, clr = "Thinking...", color.RGB(0xFF, 0xC0, 0)
+	case solver.Status == SolveWon:
+		text, clr = "Can be won", color.RGB(0, 0xA0, 0)
+	case solver.Status == SolveLost:
+		text, clr = "Can not be won", color.RGB(0xC0, 0, 0)
+	default:
+		text, clr = "Unknown", color.RGB(0x80, 0x80, 0x80)
+	}
+
+	const size = 10
+	textWidth := ui.Font.TextWidth(text)
+	x := window.Width - textWidth - size - 15
+	renderer.RenderSolidRectWH(x, (MenuBarHeight-size)/2, size, size, clr)
+	renderer.RenderText(text, ui.Font, x+size+5, (MenuBarHeight-ui.Font.TextHeight(text))/2, color.Black)
+}
+
+/* DealMatcher reports whether solver wins deal N and whether deal is what options ask for. It runs on goroutine of DealSearch, so it must not touch the game. */
+type DealMatcher func(N int, stop *atomic.Bool) (bool, bool)
+
+/*
+ * DealSearch tries random deals on its own goroutine until one of them matches, so that window keeps responding while they are solved.
+ * If none of DealTries deals matches, the first winnable one is taken, or any deal at all when solver wins none.
+ */
+type DealSearch struct {
+	Result chan int
+	Stop   *atomic.Bool
+}
+
+func RandomDeal() int {
+	return (rand.Int() % 30000) + 1
+}
+
+func StartDealSearch(match DealMatcher) *DealSearch {
+	search := &DealSearch{Result: make(chan int, 1), Stop: new(atomic.Bool)}
+	go search.Run(match)
+	return search
+}
+
+func (search *DealSearch) Run(match DealMatcher) {
+	var winnable int
+
+	for i := 0; (i < DealTries) && (!search.Stop.Load()); i++ {
+		N := RandomDeal()
+
+		won, matches := match(N, search.Stop)
+		if matches {
+			search.Result <- N
+			return
+		}
+		if (won) && (winnable == 0) {
+			winnable = N
+		}
+	}
+	if winnable == 0 {
+		winnable = RandomDeal()
+	}
+	search.Result <- winnable
+}
+
+/* Poll returns deal which search has found, if it is done. */
+func (search *DealSearch) Poll() (int, bool) {
+	select {
+	case N := <-search.Result:
+		return N, true
+	default:
+		return 0, false
+	}
+}
+
+/* Cancel makes search give up soon. Its result is never picked up. */
+func (search *DealSearch) Cancel() {
+	search.Stop.Store(true)
+}
+
+/* DrawDealSearch tells that new deal is being looked for in the middle of the window. */
+func DrawDealSearch(window *gui.Window, renderer gui.Renderer, ui *gui.UI) {
+	const text = "Looking for a deal..."
+	textWidth := ui.Font.TextWidth(text)
+	textHeight := ui.Font.TextHeight(text)
+	renderer.RenderText(text, ui.Font, window.Width/2-textWidth/2, window.Height/2-textHeight/2, color.White)
+}
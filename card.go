@@ -1,5 +1,7 @@
 package main
 
+import "fmt"
+
 type ValueType int16
 
 const (
@@ -36,22 +38,152 @@ type Card struct {
 	X, Y int16
 
 	Selected bool
+	FaceDown bool
 }
 
+type BuildRule int
+
 const (
-	CardWidth    = 71
-	CardHeight   = 96
-	CardYPadding = 18
+	BuildAlternate BuildRule = iota
+	BuildSuit
+	BuildAny
 )
 
+/* Sizes of cards in atlas. Cards are drawn CardScale/ScaleUnit times bigger, see SetCardScale. */
+const (
+	BaseCardWidth            = 71
+	BaseCardHeight           = 96
+	BaseCardYPadding         = 18
+	BaseCardYPaddingFaceDown = 5
+	BaseCardXPaddingWaste    = 14
+)
+
+var (
+	CardWidth            = BaseCardWidth
+	CardHeight           = BaseCardHeight
+	CardYPadding         = BaseCardYPadding
+	CardYPaddingFaceDown = BaseCardYPaddingFaceDown
+	CardXPaddingWaste    = BaseCardXPaddingWaste
+
+	/* Space between columns grows with window from MinTableSpace to MaxTableSpace, wider window leaves margins at both sides. */
+	MinTableSpace = 7
+	MaxTableSpace = CardWidth / 2
+)
+
+/* Card is written as value and suit characters, lower-case for face-down cards and "--" for blank. */
+const (
+	ValueChars = "-A23456789TJQK"
+	SuitChars  = "-CDHS"
+)
+
+func AppendCard(buffer []byte, card *Card) []byte {
+	if card.Suit == Blank {
+		return append(buffer, '-', '-')
+	}
+
+	value := ValueChars[card.Value]
+	suit := SuitChars[card.Suit]
+	if card.FaceDown {
+		/* NOTE(anton2920): digits are not affected. */
+		value |= 0x20
+		suit |= 0x20
+	}
+	return append(buffer, value, suit)
+}
+
+func ParseCard(s string) (Card, error) {
+	var card Card
+
+	if s == "--" {
+		return card, nil
+	}
+	if len(s) != 2 {
+		return card, fmt.Errorf("invalid card %q", s)
+	}
+
+	value, suit := s[0], s[1]
+	if (suit >= 'a') && (suit <= 'z') {
+		card.FaceDown = true
+		suit -= 'a' - 'A'
+		if (value >= 'a') && (value <= 'z') {
+			value -= 'a' - 'A'
+		}
+	}
+	for i := int(Ace); i <= int(King); i++ {
+		if ValueChars[i] == value {
+			card.Value = ValueType(i)
+		}
+	}
+	for i := int(Clubs); i <= int(Aces); i++ {
+		if SuitChars[i] == suit {
+			card.Suit = SuitType(i)
+		}
+	}
+	if (card.Value == None) || (card.Suit == Blank) {
+		return card, fmt.Errorf("invalid card %q", s)
+	}
+
+	return card, nil
+}
+
 func (card *Card) Red() bool {
 	return (card.Suit == Diamonds) || (card.Suit == Hearts)
 }
 
-func CanMove(src, dst *Card) bool {
-	return (src != nil) && (src.Suit != Blank) && (dst != nil) && (dst.Suit != Blank) && (src.Red() != dst.Red()) && (dst.Value-src.Value == 1)
+/* NextValue returns value which follows v. With wrap King is followed by Ace. */
+func NextValue(v ValueType, wrap bool) ValueType {
+	if (wrap) && (v == King) {
+		return Ace
+	}
+	return v + 1
+}
+
+func CanBuild(src, dst *Card, rule BuildRule, wrap bool) bool {
+	if (src == nil) || (src.Suit == Blank) || (src.FaceDown) || (dst == nil) || (dst.Suit == Blank) || (dst.FaceDown) || (NextValue(src.Value, wrap) != dst.Value) {
+		return false
+	}
+
+	switch rule {
+	case BuildAlternate:
+		return src.Red() != dst.Red()
+	case BuildSuit:
+		return src.Suit == dst.Suit
+	}
+	return true
+}
+
+/* CanMove2Foundation reports whether src may be put on foundation with dst on top. Foundations start at base. */
+func CanMove2Foundation(src, dst *Card, base ValueType, wrap bool) bool {
+	if (src == nil) || (src.Suit == Blank) || (src.FaceDown) {
+		return false
+	}
+	if (dst == nil) || (dst.Suit == Blank) {
+		return src.Value == base
+	}
+	return (src.Suit == dst.Suit) && (NextValue(dst.Value, wrap) == src.Value)
+}
+
+/* IsCompleteSuit reports whether cards go from King to Ace in the same suit. */
+func IsCompleteSuit(cards []Card) bool {
+	if (len(cards) != 13) || (cards[0].Value != King) {
+		return false
+	}
+	for i := 0; i < len(cards)-1; i++ {
+		if !CanBuild(&cards[i+1], &cards[i], BuildSuit, false) {
+			return false
+		}
+	}
+	return true
 }
 
-func CanMove2Goal(src, dst *Card) bool {
-	return ((src.Suit == dst.Suit) && (src.Value-dst.Value == 1)) || ((src.Value == 1) && (dst.Suit == Blank))
+func NewDeck(decks int) []Card {
+	cards := make([]Card, 0, decks*52)
+	for k := 0; k < decks; k++ {
+		for i := Clubs; i <= Aces; i++ {
+			for j := Ace; j <= King; j++ {
+				cards = append(cards, Card{Value: j, Suit: i})
+			}
+		}
+	}
+	return cards
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/anton2920/gofa/trace"
+)
+
+type DifficultyType int
+
+const (
+	DifficultyEasy DifficultyType = iota
+	DifficultyMedium
+	DifficultyHard
+	DifficultyExpert
+)
+
+var DifficultyNames = [...]string{
+	DifficultyEasy:   "easy",
+	DifficultyMedium: "medium",
+	DifficultyHard:   "hard",
+	DifficultyExpert: "expert",
+}
+
+func (difficulty DifficultyType) String() string {
+	return DifficultyNames[difficulty]
+}
+
+/*
+ * DifficultyScores are the lowest scores of medium, hard and expert deals.
+ * NOTE(anton2920): they are 25th, 50th and 90th percentiles of scores of FreeCell deals 1..1000, so that quarter of won deals is easy, quarter is medium and tenth is expert.
+ * Scores were measured with 'solitaire survey -rate -limit 20000 1..1000', which rates deals with DealSolveLimit just like new games do. It won 990 deals, their scores ranged from 113 to 407 with median 177.
+ * Thresholds must be measured again whenever RateFreeCell or solver changes.
+ */
+var DifficultyScores = [...]int{
+	DifficultyMedium: 155,
+	DifficultyHard:   177,
+	DifficultyExpert: 232,
+}
+
+/* RateCellsLimit is the number of positions solver examines with fewer free cells. It is small, since proving that deal can not be won takes long. */
+const RateCellsLimit = 20000
+
+/* DealRating describes how hard it is to win the deal. Cells, Length and Score are set only when solver wins it. */
+type DealRating struct {
+	Solution Solution
+
+	/* Cells is the fewest number of free cells with which solver wins. */
+	Cells int
+
+	/* Length is the number of moves in the shortest solution solver finds. */
+	Length int
+
+	/* Score grows with solver effort, length of the shortest solution and number of free cells it needs. */
+	Score int
+}
+
+/*
+ * RateFreeCell solves the deal with all free cells, then with fewer and fewer of them until it fails. It gives up when stop is set.
+ * NOTE(anton2920): depth-first solution is often much longer than it has to be, so deal is also solved best-first and the shorter of two solutions is taken as its length.
+ */
+func RateFreeCell(pos FreeCellPosition, limit int, stop *atomic.Bool) DealRating {
+	defer trace.End(trace.Begin(""))
+
+	var rating DealRating
+
+	rating.Solution = SolveFreeCellPosition(pos, limit, stop)
+	if rating.Solution.Status != SolveWon {
+		return rating
+	}
+
+	rating.Length = len(rating.Solution.Moves)
+	if best := SolveFreeCellBest(pos, limit, stop); (best.Status == SolveWon) && (len(best.Moves) < rating.Length) {
+		rating.Length = len(best.Moves)
+	}
+
+	rating.Cells = len(pos.Cells)
+	for rating.Cells > 0 {
+		if pos.Cells[rating.Cells-1].Suit != Blank {
+			break
+		}
+		pos.Cells = pos.Cells[:rating.Cells-1]
+		if SolveFreeCellPosition(pos, RateCellsLimit, stop).Status != SolveWon {
+			break
+		}
+		rating.Cells--
+	}
+
+	rating.Score = 10*bits.Len(uint(rating.Solution.Nodes)) + rating.Length/2 + 20*rating.Cells
+	return rating
+}
+
+func (rating *DealRating) Difficulty() DifficultyType {
+	difficulty := DifficultyEasy
+	for d := DifficultyMedium; d <= DifficultyExpert; d++ {
+		if rating.Score >= DifficultyScores[d] {
+			difficulty = d
+		}
+	}
+	return difficulty
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/color"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* Glyph is a small bitmap, '#' marks pixels which are drawn. Glyphs are scaled by drawing every pixel as a square. */
+type Glyph []string
+
+var RankGlyphs = [...]Glyph{
+	Ace: {
+		".###.",
+		"#...#",
+		"#...#",
+		"#####",
+		"#...#",
+		"#...#",
+		"#...#",
+	},
+	Two: {
+		".###.",
+		"#...#",
+		"....#",
+		"...#.",
+		"..#..",
+		".#...",
+		"#####",
+	},
+	Three: {
+		"####.",
+		"....#",
+		"....#",
+		".###.",
+		"....#",
+		"....#",
+		"####.",
+	},
+	Four: {
+		"...#.",
+		"..##.",
+		".#.#.",
+		"#..#.",
+		"#####",
+		"...#.",
+		"...#.",
+	},
+	Five: {
+		"#####",
+		"#....",
+		"####.",
+		"....#",
+		"....#",
+		"#...#",
+		".###.",
+	},
+	Six: {
+		".###.",
+		"#....",
+		"#....",
+		"####.",
+		"#...#",
+		"#...#",
+		".###.",
+	},
+	Seven: {
+		"#####",
+		"....#",
+		"...#.",
+		"..#..",
+		".#...",
+		".#...",
+		".#...",
+	},
+	Eight: {
+		".###.",
+		"#...#",
+		"#...#",
+		".###.",
+		"#...#",
+		"#...#",
+		".###.",
+	},
+	Nine: {
+		".###.",
+		"#...#",
+		"#...#",
+		".####",
+		"....#",
+		"....#",
+		".###.",
+	},
+	Ten: {
+		".#..##.",
+		"##.#..#",
+		".#.#..#",
+		".#.#..#",
+		".#.#..#",
+		".#.#..#",
+		"###.##.",
+	},
+	Jack: {
+		"..###",
+		"...#.",
+		"...#.",
+		"...#.",
+		"#..#.",
+		"#..#.",
+		".##..",
+	},
+	Queen: {
+		".###.",
+		"#...#",
+		"#...#",
+		"#...#",
+		"#.#.#",
+		"#..#.",
+		".##.#",
+	},
+	King: {
+		"#...#",
+		"#..#.",
+		"#.#..",
+		"##...",
+		"#.#..",
+		"#..#.",
+		"#...#",
+	},
+}
+
+var SuitGlyphs = [...]Glyph{
+	Clubs: {
+		"..###..",
+		"..###..",
+		"#######",
+		"#######",
+		"##.#.##",
+		"...#...",
+		"..###..",
+	},
+	Diamonds: {
+		"...#...",
+		"..###..",
+		".#####.",
+		"#######",
+		".#####.",
+		"..###..",
+		"...#...",
+	},
+	Hearts: {
+		".##.##.",
+		"#######",
+		"#######",
+		"#######",
+		".#####.",
+		"..###..",
+		"...#...",
+	},
+	Aces: {
+		"...#...",
+		"..###..",
+		".#####.",
+		"#######",
+		"#######",
+		".#.#.#.",
+		"..###..",
+	},
+}
+
+/* Pip is place of suit symbol on drawn card. Columns go from 0 to 2 and rows go from 0 to 8, from top to bottom. */
+type Pip struct {
+	Column, Row int
+}
+
+var CardPips = [...][]Pip{
+	Ace:   {{1, 4}},
+	Two:   {{1, 0}, {1, 8}},
+	Three: {{1, 0}, {1, 4}, {1, 8}},
+	Four:  {{0, 0}, {2, 0}, {0, 8}, {2, 8}},
+	Five:  {{0, 0}, {2, 0}, {1, 4}, {0, 8}, {2, 8}},
+	Six:   {{0, 0}, {2, 0}, {0, 4}, {2, 4}, {0, 8}, {2, 8}},
+	Seven: {{0, 0}, {2, 0}, {1, 2}, {0, 4}, {2, 4}, {0, 8}, {2, 8}},
+	Eight: {{0, 0}, {2, 0}, {1, 2}, {0, 4}, {2, 4}, {1, 6}, {0, 8}, {2, 8}},
+	Nine:  {{0, 0}, {2, 0}, {0, 3}, {2, 3}, {1, 4}, {0, 5}, {2, 5}, {0, 8}, {2, 8}},
+	Ten:   {{0, 0}, {2, 0}, {1, 1}, {0, 3}, {2, 3}, {0, 5}, {2, 5}, {1, 7}, {0, 8}, {2, 8}},
+}
+
+type RGB struct {
+	R, G, B uint8
+}
+
+func (c RGB) Color() color.Color {
+	return color.RGB(c.R, c.G, c.B)
+}
+
+func (c RGB) Inverted() RGB {
+	return RGB{0xFF - c.R, 0xFF - c.G, 0xFF - c.B}
+}
+
+/* CardPalette gives colour of each suit on drawn cards. Rules do not use it, see Card.Red. */
+type CardPalette [Aces + 1]RGB
+
+var (
+	TwoColorPalette  = CardPalette{Clubs: {0, 0, 0}, Diamonds: {0xC0, 0, 0}, Hearts: {0xC0, 0, 0}, Aces: {0, 0, 0}}
+	FourColorPalette = CardPalette{Clubs: {0, 0x80, 0}, Diamonds: {0, 0, 0xC0}, Hearts: {0xC0, 0, 0}, Aces: {0, 0, 0}}
+)
+
+var SuitColorNames = [...]string{"red and black", "four colours"}
+
+/* Palette is used for drawn cards, it is chosen with SuitColorOption. Cards from theme are recoloured to match, see RecolorSuit. */
+var (
+	Palette         = &TwoColorPalette
+	SuitColorOption int
+)
+
+var IndexNames = [...]string{"normal", "large"}
+
+var IndexOption int
+
+type CardStyle int
+
+const (
+	CardStyleTheme CardStyle = iota
+	CardStyleDrawn
+	CardStyleDrawnEnlarged
+)
+
+var CardStyleNames = [...]string{"from theme", "drawn", "drawn when enlarged"}
+
+var CardStyleOption int
+
+/* DrawnCards reports whether cards are drawn rather than taken from theme. Themes without cards always have them drawn. */
+func DrawnCards() bool {
+	switch CardStyle(CardStyleOption) {
+	case CardStyleDrawn:
+		return true
+	case CardStyleDrawnEnlarged:
+		if CardScale > ScaleUnit {
+			return true
+		}
+	}
+	return CurrentTheme.Cards.Width == 0
+}
+
+/* DrawGlyph draws glyph with its top left corner at x, y. Every pixel of glyph becomes square of size px. Flipped glyph is turned upside down. */
+func DrawGlyph(renderer gui.Renderer, glyph Glyph, x, y, px int, flip bool, clr color.Color) {
+	for j := 0; j < len(glyph); j++ {
+		row := glyph[j]
+		if flip {
+			row = glyph[len(glyph)-1-j]
+		}
+
+		/* NOTE(anton2920): runs of pixels are drawn as one rectangle. */
+		for i := 0; i < len(row); {
+			if row[i] != '#' {
+				i++
+				continue
+			}
+			start := i
+			for (i < len(row)) && (row[i] == '#') {
+				i++
+			}
+
+			x0 := start
+			if flip {
+				x0 = len(row) - i
+			}
+			renderer.RenderSolidRectWH(x+x0*px, y+j*px, (i-start)*px, px, clr)
+		}
+	}
+}
+
+func GlyphWidth(glyph Glyph) int {
+	return len(glyph[0])
+}
+
+/* FillRoundedRect fills rectangle with corners rounded with radius r. */
+func FillRoundedRect(renderer gui.Renderer, x, y, width, height, r int, clr color.Color) {
+	for i := 0; i < r; i++ {
+		/* NOTE(anton2920): inset of each row is found from x^2 + y^2 = r^2, measured at the middle of the row. */
+		dy := 2*(r-i) - 1
+		inset := r
+		for dx := 0; dx <= 2*r; dx++ {
+			if dx*dx+dy*dy <= 4*r*r {
+				inset = r - (dx+1)/2
+			}
+		}
+		renderer.RenderSolidRectWH(x+inset, y+i, width-2*inset, 1, clr)
+		renderer.RenderSolidRectWH(x+inset, y+height-1-i, width-2*inset, 1, clr)
+	}
+	renderer.RenderSolidRectWH(x, y+r, width, height-2*r, clr)
+}
+
+/*
+ * DrawnCard draws face of the card with rectangles, so that it looks the same at any size. Selected card is drawn inverted, as in atlas.
+ * Number cards have pips, court cards have framed rank between two pips.
+ */
+func DrawnCard(renderer gui.Renderer, card *Card, x, y int) {
+	defer trace.End(trace.Begin(""))
+
+	paper, ink, border := CardColors(card)
+
+	width, height := CardWidth, CardHeight
+	r := max(CardWidth/12, 2)
+	FillRoundedRect(renderer, x, y, width, height, r, border)
+	FillRoundedRect(renderer, x+1, y+1, width-2, height-2, r-1, paper)
+
+	/* Corner indices, the bottom one is upside down. */
+	rank, suit := RankGlyphs[card.Value], SuitGlyphs[card.Suit]
+	ipx := IndexPixelSize()
+	margin := IndexMargin()
+	indexWidth := GlyphWidth(suit) * ipx
+	indexHeight := (len(rank) + 1 + len(suit)) * ipx
+
+	DrawIndex(renderer, card, x+margin, y+margin, ipx, false, ink)
+	DrawIndex(renderer, card, x+width-margin-indexWidth, y+height-margin-indexHeight, ipx, true, ink)
+
+	/* Pips are put on grid between indices. They are made smaller when three of them do not fit between large indices. */
+	ppx := max(min(CardWidth/32, (width-4*margin-2*indexWidth)/(3*GlyphWidth(suit))), 1)
+	pip := GlyphWidth(suit) * ppx
+	left := x + margin + indexWidth + margin
+	right := x + width - margin - indexWidth - margin - pip
+	top := y + margin + ipx
+	bottom := y + height - margin - ipx - pip
+
+	if card.Value < Jack {
+		pips := CardPips[card.Value]
+		for i := 0; i < len(pips); i++ {
+			px := left + pips[i].Column*(right-left)/2
+			py := top + pips[i].Row*(bottom-top)/8
+			DrawGlyph(renderer, suit, px, py, ppx, pips[i].Row > 4, ink)
+		}
+	} else {
+		DrawRectWithShadow(renderer, left, top, right+pip, bottom+pip, ink, ink)
+
+		big := 2 * ppx
+		cx := (left + right + pip) / 2
+		cy := (top + bottom + pip) / 2
+		DrawGlyph(renderer, rank, cx-GlyphWidth(rank)*big/2, cy-len(rank)*big/2, big, false, ink)
+		DrawGlyph(renderer, suit, cx-pip/2, top+ppx*2, ppx, false, ink)
+		DrawGlyph(renderer, suit, cx-pip/2, bottom-ppx*2, ppx, true, ink)
+	}
+}
+
+/* CardColors returns colours of paper, suit and border of the card. Selected card is inverted. */
+func CardColors(card *Card) (color.Color, color.Color, color.Color) {
+	paper, ink, border := RGB{0xFF, 0xFF, 0xFF}, Palette[card.Suit], RGB{0, 0, 0}
+	if card.Selected {
+		paper, ink, border = paper.Inverted(), ink.Inverted(), border.Inverted()
+	}
+	return paper.Color(), ink.Color(), border.Color()
+}
+
+/* DrawIndex draws rank above suit with top left corner at x, y. Flipped index is turned upside down, so suit goes first. */
+func DrawIndex(renderer gui.Renderer, card *Card, x, y, px int, flip bool, ink color.Color) {
+	rank, suit := RankGlyphs[card.Value], SuitGlyphs[card.Suit]
+	rankX := x + (GlyphWidth(suit)-GlyphWidth(rank))*px/2
+
+	if flip {
+		DrawGlyph(renderer, suit, x, y, px, true, ink)
+		DrawGlyph(renderer, rank, rankX, y+(len(suit)+1)*px, px, true, ink)
+	} else {
+		DrawGlyph(renderer, rank, rankX, y, px, false, ink)
+		DrawGlyph(renderer, suit, x, y+(len(rank)+1)*px, px, false, ink)
+	}
+}
+
+/* DrawLargeIndex covers top left index of card from theme with large one. */
+func DrawLargeIndex(renderer gui.Renderer, card *Card, x, y int) {
+	defer trace.End(trace.Begin(""))
+
+	paper, ink, _ := CardColors(card)
+
+	px := IndexPixelSize()
+	margin := IndexMargin()
+	suit := SuitGlyphs[card.Suit]
+	renderer.RenderSolidRectWH(x+margin, y+margin, (GlyphWidth(suit)+2)*px, (len(RankGlyphs[card.Value])+len(suit)+3)*px, paper)
+	DrawIndex(renderer, card, x+margin+px, y+margin+px, px, false, ink)
+}
+
+/* IndexPixelSize returns size of pixel of glyphs in corners of drawn cards. Large index is twice as big. */
+func IndexPixelSize() int {
+	px := max(CardWidth/48, 1)
+	if IndexOption == 1 {
+		px *= 2
+	}
+	return px
+}
+
+func IndexMargin() int {
+	return max(CardWidth/24, 2)
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"math/rand"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/anton2920/gofa/gui"
 	"github.com/anton2920/gofa/gui/color"
 	"github.com/anton2920/gofa/gui/gr"
-	"github.com/anton2920/gofa/slices"
 	"github.com/anton2920/gofa/trace"
-	"github.com/anton2920/gofa/util"
 )
 
 type GameState int
@@ -27,6 +29,62 @@ const (
 	CursorDown
 )
 
+/* SmartDestination is a kind of place where smart move may put the card. */
+type SmartDestination int
+
+const (
+	SmartFoundation SmartDestination = iota
+	SmartTableau
+	SmartEmptyColumn
+	SmartFreeCell
+)
+
+/* SmartMoveOrders are orders in which smart move tries destinations, SmartOrderOption chooses one of them. */
+var SmartMoveOrders = [...][4]SmartDestination{
+	{SmartFoundation, SmartTableau, SmartEmptyColumn, SmartFreeCell},
+	{SmartFoundation, SmartTableau, SmartFreeCell, SmartEmptyColumn},
+	{SmartTableau, SmartFoundation, SmartEmptyColumn, SmartFreeCell},
+	{SmartTableau, SmartFoundation, SmartFreeCell, SmartEmptyColumn},
+}
+
+var SmartMoveOrderNames = []string{
+	"home, card, column, cell",
+	"home, card, cell, column",
+	"card, home, column, cell",
+	"card, home, cell, column",
+}
+
+/* AutoplayType is the policy by which cards are sent to foundations without player. */
+type AutoplayType int
+
+const (
+	/* AutoplaySafe sends card only when no card left on the table could be built on it. */
+	AutoplaySafe AutoplayType = iota
+
+	/* AutoplayAggressive sends every card which may go to foundation. */
+	AutoplayAggressive
+
+	AutoplayOff
+)
+
+var AutoplayNames = [...]string{
+	AutoplaySafe:       "safe",
+	AutoplayAggressive: "aggressive",
+	AutoplayOff:        "off",
+}
+
+/* FinishMoveTime is how long each card flies to foundation when game is finished. */
+const FinishMoveTime = 80 * time.Millisecond
+
+/* EmptyColumnType tells how many cards go into empty column when run is moved there. */
+type EmptyColumnType int
+
+const (
+	EmptyColumnAsk EmptyColumnType = iota
+	EmptyColumnRun
+	EmptyColumnCard
+)
+
 type FreeCell struct {
 	/* Window-related stuff. */
 	Window   *gui.Window
@@ -35,22 +93,53 @@ type FreeCell struct {
 	Assets   *gr.Pixmap
 
 	/* Game-related stuff. */
-	State GameState
+	State  GameState
+	Number int
 
 	Table     []Card
-	FreeCells [4]Card
-	Goals     [4]Card
-
-	SelectedCard    *Card
-	AutoplayAllowed bool
+	Rules     *Rules
+	FreeCells []Card
+	Goals     []Card
+
+	SelectedCard *Card
+
+	/* Returned is the card which has been moved back from foundation. Autoplay leaves it on the table until other card is selected. */
+	Returned Card
+
+	/* History has moves player has made. MoveFrom is name of pile the move which is being made starts from, see BeginMove. */
+	History  History
+	MoveFrom string
+
+	/* Finishing is set while remaining cards fly to foundations one by one. Flying is the card in the air, it is Blank between cards. */
+	Finishing    bool
+	FinishOption int
+	Flying       Card
+	FlyingGoal   int
+	FlyingStart  time.Time
+
+	/* Asking is set while player chooses between moving run and single card into empty column AskColumn. */
+	Asking    bool
+	AskColumn int
+
+	/* DifficultyOption is 0 for any winnable deal, DifficultyType+1 for deal of that difficulty and DifficultyAnyOption for any deal at all. */
+	DifficultyOption  int
+	SmartMoveOption   int
+	SmartOrderOption  int
+	EmptyColumnOption int
+	AutoplayOption    int
+	OptionList        []Option
 
 	FaceDirection int
 	Cursor        CursorType
 
-	RandSeed int
+	/* Search looks for new deal, see NewRandomGame. */
+	Search *DealSearch
 
-	/* Measurements. */
-	Width int
+	/* Measurements. Width is the width of board, which is centred in window of LayoutWidth with cards of LayoutScale. */
+	Width       int
+	Left        int
+	LayoutWidth int
+	LayoutScale int
 
 	/* TODO(anton2920): replace with UI Layout menu height. */
 	MenuHeight int
@@ -61,10 +150,15 @@ type FreeCell struct {
 
 	TableLeft    int
 	TableTop     int
+	TableSpace   int
 	TableColumns int
+
+	/* FaceX and FaceY are the top left corner of frame around the face. */
+	FaceX int
+	FaceY int
 }
 
-func NewFreeCell(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *gr.Pixmap) FreeCell {
+func NewFreeCell(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *gr.Pixmap, rules *Rules) FreeCell {
 	var game FreeCell
 
 	game.Window = window
@@ -72,78 +166,225 @@ func NewFreeCell(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *
 	game.UI = ui
 	game.Assets = assets
 
+	game.Rules = rules
 	game.Table = make([]Card, 0, 52)
+	game.FreeCells = make([]Card, rules.Cells)
+	game.Goals = make([]Card, 4)
 
-	game.Width = 632
 	game.MenuHeight = 20
 
 	game.PlaceholderTop = game.MenuHeight
-	game.PlaceholderWidth = CardWidth
-	game.PlaceholderHeight = CardHeight
+	game.TableColumns = rules.Columns
 
-	game.TableLeft = 7
-	game.TableTop = 126
-	game.TableColumns = 8
+	game.Resize(DefaultWindowWidth)
 
 	return game
 }
 
-func (game *FreeCell) Rand() int {
-	game.RandSeed = (game.RandSeed*214013 + 2531011) & ((1 << 31) - 1)
-	return game.RandSeed >> 16
-}
-
-func (game *FreeCell) Deal(N int) {
-	game.Table = game.Table[:0]
-	game.AutoplayAllowed = false
-	game.SelectedCard = nil
-
+/* ShuffleFreeCell appends cards of deal N to deck in order in which they are dealt to columns, as Microsoft FreeCell does. */
+func ShuffleFreeCell(deck []Card, N int) []Card {
+	start := len(deck)
 	for j := King; j >= Ace; j-- {
 		for i := Aces; i >= Clubs; i-- {
-			game.Table = append(game.Table, Card{Value: j, Suit: i})
+			deck = append(deck, Card{Value: j, Suit: i})
 		}
 	}
 
-	game.RandSeed = N
-	for i := 0; i < len(game.Table)-1; i++ {
-		j := (len(game.Table) - 1) - game.Rand()%(len(game.Table)-i)
-		game.Table[i], game.Table[j] = game.Table[j], game.Table[i]
+	cards := deck[start:]
+	seed := N
+	for i := 0; i < len(cards)-1; i++ {
+		seed = (seed*214013 + 2531011) & ((1 << 31) - 1)
+		j := (len(cards) - 1) - (seed>>16)%(len(cards)-i)
+		cards[i], cards[j] = cards[j], cards[i]
 	}
+	return deck
+}
 
-	for k := 0; k < len(game.Table); k++ {
-		card := &game.Table[k]
+func (game *FreeCell) Deal(N int) {
+	game.CancelSearch()
+	game.SelectedCard = nil
+	game.Returned = Card{}
+	game.Asking = false
+	game.Finishing = false
+	game.Flying = Card{}
+	game.History.Clear()
 
-		i := k % game.TableColumns
-		j := k / game.TableColumns
-		card.X = int16(game.TableLeft + i*(game.TableLeft+CardWidth))
-		card.Y = int16(game.TableTop + j*CardYPadding)
+	game.Table = ShuffleFreeCell(game.Table[:0], N)
+	for k := 0; k < len(game.Table); k++ {
+		game.PlaceOnTable(&game.Table[k], k%game.TableColumns, k/game.TableColumns)
 	}
+	game.ClearPlaceholders()
 
+	game.Number = N
+	SetGameTitle(game.Window, game.Rules.Name, N)
+
+	game.State = GameRunning
+}
+
+/* PlaceOnTable puts card into i-th column and j-th row of the table. */
+func (game *FreeCell) PlaceOnTable(card *Card, i, j int) {
+	card.X = int16(game.ColumnX(i))
+	card.Y = int16(game.TableTop + j*CardYPadding)
+}
+
+func (game *FreeCell) ClearPlaceholders() {
 	for i := 0; i < len(game.FreeCells); i++ {
 		game.FreeCells[i].Suit = Blank
 		game.FreeCells[i].Value = 0
-		game.FreeCells[i].X = int16(i * game.PlaceholderWidth)
+	}
+	for i := 0; i < len(game.Goals); i++ {
+		game.Goals[i].Suit = Blank
+		game.Goals[i].Value = 0
+	}
+	game.LayoutPlaceholders()
+}
+
+/* FreeCellX returns left side of i-th free cell. Goals are put at the right end of the board in the same way. */
+func (game *FreeCell) FreeCellX(i int) int {
+	return game.Left + i*game.PlaceholderWidth
+}
+
+func (game *FreeCell) GoalX(i int) int {
+	return game.Left + game.Width - (len(game.Goals)-i)*game.PlaceholderWidth
+}
+
+func (game *FreeCell) LayoutPlaceholders() {
+	for i := 0; i < len(game.FreeCells); i++ {
+		game.FreeCells[i].X = int16(game.FreeCellX(i))
 		game.FreeCells[i].Y = int16(game.PlaceholderTop)
+	}
+	for i := 0; i < len(game.Goals); i++ {
+		game.Goals[i].X = int16(game.GoalX(i))
+		game.Goals[i].Y = int16(game.PlaceholderTop)
+	}
+}
 
-		game.Goals[len(game.Goals)-i-1].Suit = Blank
-		game.Goals[len(game.Goals)-i-1].Value = 0
-		game.Goals[len(game.Goals)-i-1].X = int16(game.Width - (i+1)*game.PlaceholderWidth)
-		game.Goals[len(game.Goals)-i-1].Y = int16(game.PlaceholderTop)
+/*
+ * Resize centres board in window of width, spreading columns over it. Cards on the table are moved to their columns, which are laid out again with cards of current size.
+ * NOTE(anton2920): face sits between free cells and goals.
+ */
+func (game *FreeCell) Resize(width int) {
+	defer trace.End(trace.Begin(""))
+
+	const faceWidth = 64
+
+	oldLeft := game.TableLeft
+	oldSpace := game.TableSpace
+	oldWidth := ScaleSize(BaseCardWidth, game.LayoutScale)
+
+	game.PlaceholderWidth = CardWidth
+	game.PlaceholderHeight = CardHeight
+	game.TableTop = game.PlaceholderTop + game.PlaceholderHeight + 10
+
+	columns := game.TableColumns
+	game.TableSpace = min(max((width-columns*CardWidth)/(columns+1), MinTableSpace), MaxTableSpace)
+	tableWidth := columns*CardWidth + (columns-1)*game.TableSpace
+
+	game.Width = max(tableWidth+2*game.TableSpace, (len(game.FreeCells)+len(game.Goals))*game.PlaceholderWidth+faceWidth)
+	game.Left = max((width-game.Width)/2, 0)
+	game.TableLeft = game.Left + (game.Width-tableWidth)/2
+	game.FaceX = game.Left + game.Width/2 - 19
+	game.FaceY = game.PlaceholderTop + game.PlaceholderHeight/2 - 30
+	game.LayoutWidth = width
+	game.LayoutScale = CardScale
+
+	if oldSpace > 0 {
+		for i := 0; i < len(game.Table); i++ {
+			card := &game.Table[i]
+			card.X = int16(game.ColumnX((int(card.X) - oldLeft) / (oldSpace + oldWidth)))
+		}
 	}
+	game.SortCards()
+	game.LayoutColumns()
+	game.LayoutPlaceholders()
+}
 
-	var n int
-	buffer := make([]byte, 128)
-	n += copy(buffer[n:], Title)
-	n += copy(buffer[n:], ": FreeCell Game #")
-	n += slices.PutInt(buffer[n:], N)
-	title := util.Slice2String(buffer[:n])
-	game.Window.SetTitle(title)
+/* ColumnPadding returns space between cards of column of n cards. It is made smaller when column would run past the bottom of the window. */
+func (game *FreeCell) ColumnPadding(n int) int {
+	if (game.Window == nil) || (n < 2) {
+		return CardYPadding
+	}
 
-	game.State = GameRunning
+	/* NOTE(anton2920): cards are never put closer than face-down cards in other games, so that each of them may still be clicked. */
+	space := game.Window.Height - game.TableTop - CardHeight
+	return min(max(space/(n-1), CardYPaddingFaceDown), CardYPadding)
+}
+
+/* LayoutColumns puts cards of every column one under another, compressing long columns. Table must be sorted by Y, see SortCards. */
+func (game *FreeCell) LayoutColumns() {
+	defer trace.End(trace.Begin(""))
+
+	for i := 0; i < game.TableColumns; i++ {
+		x := int16(game.ColumnX(i))
+
+		var n int
+		for j := 0; j < len(game.Table); j++ {
+			if game.Table[j].X == x {
+				n++
+			}
+		}
+		padding := game.ColumnPadding(n)
+
+		var row int
+		for j := 0; j < len(game.Table); j++ {
+			if card := &game.Table[j]; card.X == x {
+				card.Y = int16(game.TableTop + row*padding)
+				row++
+			}
+		}
+	}
 }
 
+/*
+ * NewRandomGame looks for deal of difficulty chosen in options in background, see DealSearch. Table is empty until it is found.
+ * NOTE(anton2920): any deal at all is dealt at once, since it needs no solving.
+ */
 func (game *FreeCell) NewRandomGame() {
-	game.Deal((rand.Int() % 30000) + 1)
+	game.CancelSearch()
+	if game.DifficultyOption == DifficultyAnyOption {
+		game.Deal(RandomDeal())
+		return
+	}
+
+	rules, option := game.Rules, game.DifficultyOption
+	game.Search = StartDealSearch(func(N int, stop *atomic.Bool) (bool, bool) {
+		return RateDeal(rules, option, N, stop)
+	})
+
+	game.Table = game.Table[:0]
+	game.SelectedCard = nil
+	game.Asking = false
+	game.Finishing = false
+	game.Flying = Card{}
+	game.History.Clear()
+	game.ClearPlaceholders()
+	game.State = GameNothing
+}
+
+func (game *FreeCell) CancelSearch() {
+	if game.Search != nil {
+		game.Search.Cancel()
+		game.Search = nil
+	}
+}
+
+func (game *FreeCell) Dealing() bool {
+	return game.Search != nil
+}
+
+/* RateDeal reports whether solver wins deal N and whether the deal has difficulty which option asks for, see DifficultyOption. */
+func RateDeal(rules *Rules, option int, N int, stop *atomic.Bool) (bool, bool) {
+	defer trace.End(trace.Begin(""))
+
+	pos := DealFreeCellPosition(rules, N)
+	if option == 0 {
+		won := SolveFreeCellPosition(pos, DealSolveLimit, stop).Status == SolveWon
+		return won, won
+	}
+
+	rating := RateFreeCell(pos, DealSolveLimit, stop)
+	won := rating.Solution.Status == SolveWon
+	return won, (won) && (rating.Difficulty() == DifficultyType(option-1))
 }
 
 func (game *FreeCell) NewSelectedGame(N int) {
@@ -179,58 +420,71 @@ func (game *FreeCell) AllowedToMove(onTable bool) int {
 		}
 	}
 	for i := 0; i < game.TableColumns; i++ {
-		columnRect := game.TableColumnRect(i)
-		if game.FindBottomCard(&Card{X: int16(columnRect.X0), Y: int16(columnRect.Y0)}) == nil {
+		if game.FindBottomCard(&Card{X: int16(game.ColumnX(i)), Y: int16(game.TableTop)}) == nil {
 			columns++
 		}
 	}
 	if onTable {
 		columns--
 	}
+	if !game.Rules.Supermove {
+		return 1
+	}
 	return int((freecells + 1) * (1 << columns))
 }
 
-func (game *FreeCell) PowerMove(src *Card, dst *Card, pressed bool) bool {
+/* PowerRun returns cards which supermove of run ending with src onto dst carries, starting with src. It returns nil if there is no such move. */
+func (game *FreeCell) PowerRun(src *Card, dst *Card) []*Card {
 	defer trace.End(trace.Begin(""))
 
 	if (!game.CardOnTable(src)) || (!game.CardOnTable(dst)) {
-		return false
+		return nil
 	}
 
-	var canPowerMove bool
 	cards := make([]*Card, 0, 52)
 	card := src
 	for i := 0; (i < game.AllowedToMove(false)) && (card != nil); i++ {
 		cards = append(cards, card)
-		if CanMove(card, dst) {
-			canPowerMove = true
-			break
+		if game.Rules.CanBuild(card, dst) {
+			return cards
 		}
 		next := game.FindCardAbove(card)
-		if !CanMove(card, next) {
+		if !game.Rules.CanBuild(card, next) {
 			break
 		}
 		card = next
 	}
-	if canPowerMove {
-		if pressed {
-			dstY := dst.Y
-			for i := len(cards) - 1; i >= 0; i-- {
-				card := cards[i]
-				card.X = dst.X
-				card.Y = dstY + CardYPadding
-				dstY += CardYPadding
-			}
+	return nil
+}
+
+func (game *FreeCell) PowerMove(src *Card, dst *Card, pressed bool) bool {
+	defer trace.End(trace.Begin(""))
+
+	cards := game.PowerRun(src, dst)
+	if (cards != nil) && (pressed) {
+		game.BeginMove(src)
+		defer game.EndMove(game.PileName(dst))
+
+		dstY := dst.Y
+		for i := len(cards) - 1; i >= 0; i-- {
+			card := cards[i]
+			card.X = dst.X
+			card.Y = dstY + int16(CardYPadding)
+			dstY += int16(CardYPadding)
 		}
 	}
-	return canPowerMove
+	return cards != nil
 }
 
-func (game *FreeCell) PowerMoveOnTable(src *Card, idx int, pressed bool) bool {
+/*
+ * PowerRunOnTable returns cards which supermove of run ending with src into empty column carries, starting with src.
+ * Last of them goes to the bottom of empty column, so run is cut where variant allows it to start, or is nil if it nowhere may.
+ */
+func (game *FreeCell) PowerRunOnTable(src *Card) []*Card {
 	defer trace.End(trace.Begin(""))
 
 	if !game.CardOnTable(src) {
-		return false
+		return nil
 	}
 
 	cards := make([]*Card, 0, 52)
@@ -238,34 +492,204 @@ func (game *FreeCell) PowerMoveOnTable(src *Card, idx int, pressed bool) bool {
 	for i := 0; (i < game.AllowedToMove(true)) && (card != nil); i++ {
 		cards = append(cards, card)
 		next := game.FindCardAbove(card)
-		if !CanMove(card, next) {
+		if !game.Rules.CanBuild(card, next) {
 			break
 		}
 		card = next
 	}
-	if pressed {
+
+	for (len(cards) > 0) && (!game.Rules.CanFillEmpty(cards[len(cards)-1])) {
+		cards = cards[:len(cards)-1]
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+	return cards
+}
+
+func (game *FreeCell) PowerMoveOnTable(src *Card, idx int, pressed bool) bool {
+	defer trace.End(trace.Begin(""))
+
+	cards := game.PowerRunOnTable(src)
+	if (cards != nil) && (pressed) {
+		game.BeginMove(src)
+		defer game.EndMove(ColumnName(idx))
+
 		dstX := int16(game.TableColumnRect(idx).X0)
 		dstY := int16(game.TableTop)
 		for i := len(cards) - 1; i >= 0; i-- {
 			card := cards[i]
 			card.X = dstX
 			card.Y = dstY
-			dstY += CardYPadding
+			dstY += int16(CardYPadding)
 		}
 	}
 	return len(cards) > 1
 
 }
 
+/* PlaceOnColumn puts single card from table, free cell or foundation at x, y of the table and records the move in History. */
+func (game *FreeCell) PlaceOnColumn(card *Card, x, y int16) {
+	game.BeginMove(card)
+	defer game.EndMove(ColumnName(game.ColumnAt(x)))
+
+	card.Selected = false
+	if game.CardOnTable(card) {
+		card.X = x
+		card.Y = y
+		return
+	}
+
+	moved := *card
+	moved.X = x
+	moved.Y = y
+	game.Table = append(game.Table, moved)
+	if game.IsGoal(card) {
+		game.Returned = moved
+		game.RemoveFromGoal(card)
+	} else {
+		game.RemoveFromFreecell(card)
+	}
+}
+
+/* CanMoveToEmptyColumn reports whether variant lets card, alone or with the run it ends, go into empty column. */
+func (game *FreeCell) CanMoveToEmptyColumn(card *Card) bool {
+	return (game.Rules.CanFillEmpty(card)) || (len(game.PowerRunOnTable(card)) > 1)
+}
+
+/*
+ * MoveToEmptyColumn moves card, together with the run it ends, into empty column idx. Player may be asked first whether to move the run or only the card.
+ * NOTE(anton2920): when variant does not let the card start column alone, only the run may go there, so nobody is asked.
+ */
+func (game *FreeCell) MoveToEmptyColumn(card *Card, idx int) {
+	if len(game.PowerRunOnTable(card)) > 1 {
+		option := EmptyColumnType(game.EmptyColumnOption)
+		if !game.Rules.CanFillEmpty(card) {
+			option = EmptyColumnRun
+		}
+
+		switch option {
+		case EmptyColumnAsk:
+			if game.SelectedCard != card {
+				game.RemoveSelection()
+				game.SetSelectedCard(card)
+			}
+			game.Asking = true
+			game.AskColumn = idx
+			return
+		case EmptyColumnRun:
+			game.PowerMoveOnTable(card, idx, true)
+			game.RemoveSelection()
+			return
+		}
+	}
+
+	if game.Rules.CanFillEmpty(card) {
+		game.PlaceOnColumn(card, int16(game.ColumnX(idx)), int16(game.TableTop))
+	}
+	game.RemoveSelection()
+}
+
+/* SmartMove puts card, together with the run it ends, to the first destination of preferred order which accepts it. */
+func (game *FreeCell) SmartMove(card *Card) bool {
+	defer trace.End(trace.Begin(""))
+
+	if (card == nil) || (card.Suit == Blank) {
+		return false
+	}
+
+	order := &SmartMoveOrders[game.SmartOrderOption]
+	for i := 0; i < len(order); i++ {
+		if game.SmartMoveTo(card, order[i]) {
+			game.Returned = Card{}
+			return true
+		}
+	}
+	return false
+}
+
+func (game *FreeCell) SmartMoveTo(card *Card, dst SmartDestination) bool {
+	defer trace.End(trace.Begin(""))
+
+	onTable := game.CardOnTable(card)
+
+	switch dst {
+	case SmartFoundation:
+		for i := 0; i < len(game.Goals); i++ {
+			goal := &game.Goals[i]
+			if CanMove2Foundation(card, goal, game.Rules.Base, game.Rules.Wrap) {
+				game.PutCard(card, goal)
+				return true
+			}
+		}
+	case SmartTableau:
+		for i := 0; i < game.TableColumns; i++ {
+			bottomCard := game.FindBottomCard(&Card{X: int16(game.ColumnX(i))})
+			if (bottomCard == nil) || (bottomCard == card) {
+				continue
+			}
+			if game.PowerMove(card, bottomCard, true) {
+				return true
+			} else if game.Rules.CanBuild(card, bottomCard) {
+				game.PlaceOnColumn(card, bottomCard.X, bottomCard.Y+int16(CardYPadding))
+				return true
+			}
+		}
+	case SmartEmptyColumn:
+		for i := 0; i < game.TableColumns; i++ {
+			x := int16(game.ColumnX(i))
+			if game.FindBottomCard(&Card{X: x}) != nil {
+				continue
+			}
+
+			if (onTable) && (game.RunReachesTop(card)) {
+				/* NOTE(anton2920): moving whole column to another one changes nothing. */
+				return false
+			}
+			if !game.CanMoveToEmptyColumn(card) {
+				return false
+			}
+			game.MoveToEmptyColumn(card, i)
+			return true
+		}
+	case SmartFreeCell:
+		if !onTable {
+			return false
+		}
+		for i := 0; i < len(game.FreeCells); i++ {
+			freecell := &game.FreeCells[i]
+			if freecell.Suit == Blank {
+				game.PutCard(card, freecell)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/* RunReachesTop reports whether card ends run which PowerMoveOnTable would move from the top of the column. */
+func (game *FreeCell) RunReachesTop(card *Card) bool {
+	for i := 1; i < game.AllowedToMove(true); i++ {
+		next := game.FindCardAbove(card)
+		if (next == nil) || (!game.Rules.CanBuild(card, next)) {
+			break
+		}
+		card = next
+	}
+	return game.FindCardAbove(card) == nil
+}
+
+/* FindCardAbove returns card which is right above card in its column. Columns may be compressed, so it is the nearest one rather than one at CardYPadding. */
 func (game *FreeCell) FindCardAbove(card *Card) *Card {
 	defer trace.End(trace.Begin(""))
 
+	var above *Card
 	for i := 0; i < len(game.Table); i++ {
-		if (game.Table[i].X == card.X) && (game.Table[i].Y == card.Y-CardYPadding) {
-			return &game.Table[i]
+		if (game.Table[i].X == card.X) && (game.Table[i].Y < card.Y) && ((above == nil) || (game.Table[i].Y > above.Y)) {
+			above = &game.Table[i]
 		}
 	}
-	return nil
+	return above
 }
 
 func (game *FreeCell) FindCardOnTable(card *Card) int {
@@ -299,15 +723,38 @@ func (game *FreeCell) RemoveFromFreecell(card *Card) {
 	for i := 0; i < len(game.FreeCells); i++ {
 		if &game.FreeCells[i] == card {
 			card.Suit = Blank
-			card.X = int16(i * game.PlaceholderWidth)
+			card.X = int16(game.FreeCellX(i))
 			card.Y = int16(game.PlaceholderTop)
 			break
 		}
 	}
 }
 
+func (game *FreeCell) IsGoal(card *Card) bool {
+	for i := 0; i < len(game.Goals); i++ {
+		if &game.Goals[i] == card {
+			return true
+		}
+	}
+	return false
+}
+
+/* RemoveFromGoal leaves card under the top one on foundation. */
+func (game *FreeCell) RemoveFromGoal(card *Card) {
+	switch {
+	case card.Value == game.Rules.Base:
+		card.Suit = Blank
+		card.Value = 0
+	case card.Value == Ace:
+		card.Value = King
+	default:
+		card.Value--
+	}
+}
+
 func (game *FreeCell) SetSelectedCard(card *Card) {
 	if card != nil {
+		game.Returned = Card{}
 		game.SelectedCard = card
 		game.SelectedCard.Selected = true
 	}
@@ -317,7 +764,6 @@ func (game *FreeCell) RemoveSelection() {
 	if game.SelectedCard != nil {
 		game.SelectedCard.Selected = false
 		game.SelectedCard = nil
-		game.AutoplayAllowed = true
 	}
 }
 
@@ -330,73 +776,64 @@ func (game *FreeCell) MoveCard(src, dst *Card) {
 	dst.Y = int16(rect.Y0)
 }
 
+/* PutCard moves card of player to free cell or foundation dst and records the move in History. */
+func (game *FreeCell) PutCard(card, dst *Card) {
+	game.BeginMove(card)
+	game.MoveCard(card, dst)
+	game.RemoveFromFreecell(card)
+	game.RemoveFromTable(card)
+	game.EndMove(game.PileName(dst))
+}
+
 func (game *FreeCell) DrawMenu() {
 	defer trace.End(trace.Begin(""))
 
-	game.Renderer.RenderSolidRectWH(0, 0, game.Width, game.MenuHeight, color.RGB(0xD4, 0xD0, 0xC8))
+	game.Renderer.RenderSolidRectWH(0, 0, game.Window.Width, game.MenuHeight, color.RGB(0xD4, 0xD0, 0xC8))
 }
 
 func (game *FreeCell) DrawBackground() {
 	defer trace.End(trace.Begin(""))
 
-	game.Renderer.Clear(color.RGB(0, 127, 0))
+	table := CurrentTable()
+	DrawTable(game.Window, game.Renderer)
 
 	for i := 0; i < len(game.FreeCells); i++ {
-		x := i * game.PlaceholderWidth
+		x := game.FreeCellX(i)
 		y := game.PlaceholderTop
 
-		DrawRectWithShadow(game.Renderer, x, y, x+game.PlaceholderWidth-1, y+game.PlaceholderHeight-1, color.Black, color.Green)
-		DrawRectWithShadow(game.Renderer, game.Width-game.PlaceholderWidth-x, y, game.Width-x-1, y+game.PlaceholderHeight-1, color.Black, color.Green)
+		DrawRectWithShadow(game.Renderer, x, y, x+game.PlaceholderWidth-1, y+game.PlaceholderHeight-1, table.Dark, table.Light)
+	}
+	for i := 0; i < len(game.Goals); i++ {
+		x := game.GoalX(i)
+		y := game.PlaceholderTop
+
+		DrawRectWithShadow(game.Renderer, x, y, x+game.PlaceholderWidth-1, y+game.PlaceholderHeight-1, table.Dark, table.Light)
 	}
 
-	DrawRectWithShadow(game.Renderer, 297, 38, 334, 75, color.Green, color.Black)
+	DrawRectWithShadow(game.Renderer, game.FaceX, game.FaceY, game.FaceX+37, game.FaceY+37, table.Light, table.Dark)
 }
 
 func (game *FreeCell) DrawFace() {
 	defer trace.End(trace.Begin(""))
 
-	const x = 298
-	const y = 39
-	const width = 36
-	game.Renderer.RenderPixmap(game.Assets.Sub(320+int(width*game.FaceDirection), 453, 355+int(width*game.FaceDirection), 488), x, y)
+	game.Renderer.RenderPixmap(CurrentTheme.Face[game.FaceDirection].Sub(game.Assets), game.FaceX+1, game.FaceY+1)
 }
 
 func (game *FreeCell) DrawGiantFace() {
 	defer trace.End(trace.Begin(""))
 
-	game.Renderer.RenderPixmap(game.Assets.Sub(0, 453, 320, 773), 10, 126)
-}
-
-/* TODO(anton2929): store it with card? */
-func (game *FreeCell) GetCardPixmap(card *Card) gr.Pixmap {
-	defer trace.End(trace.Begin(""))
-
-	const x = 632
-	const y = 0
-
-	i := int(card.Value - 1)
-	j := int(card.Suit-1) + int(util.Bool2Int(card.Selected)*4)
-
-	return game.Assets.Sub(x+i*CardWidth, y+j*CardHeight, x+(i+1)*CardWidth, y+(j+1)*CardHeight)
+	game.Renderer.RenderPixmap(CurrentTheme.GiantFace.Sub(game.Assets), game.Left+10, game.TableTop)
 }
 
 func (game *FreeCell) DrawCard(card *Card) {
 	defer trace.End(trace.Begin(""))
 
-	if card.Suit != Blank {
-		game.Renderer.RenderPixmap(game.GetCardPixmap(card), int(card.X), int(card.Y))
-	}
+	DrawCard(game.Renderer, card)
 }
 
 func (game *FreeCell) DrawCards() {
 	defer trace.End(trace.Begin(""))
 
-	const marginLeft = 7
-	const marginTop = 126
-
-	const paddingLeft = marginLeft
-	const paddingTop = CardYPadding
-
 	for i := 0; i < len(game.Table); i++ {
 		game.DrawCard(&game.Table[i])
 	}
@@ -410,37 +847,134 @@ func (game *FreeCell) DrawCards() {
 	}
 }
 
-func (game *FreeCell) DrawCursor() {
+/* DrawDestinations outlines every place where selected card may go, with the number of cards the move carries. */
+func (game *FreeCell) DrawDestinations() {
 	defer trace.End(trace.Begin(""))
 
-	switch game.Cursor {
-	case CursorDefault:
-		game.Window.ShowCursor()
-	case CursorUp:
-		game.Window.HideCursor()
-		old := game.Assets.Alpha
-		game.Assets.Alpha = gr.Alpha8bit
-		game.Renderer.RenderPixmap(game.Assets.Sub(406, 453, 415, 472), game.UI.MouseX, game.UI.MouseY)
-		game.Assets.Alpha = old
-	case CursorDown:
-		game.Window.HideCursor()
-		old := game.Assets.Alpha
-		game.Assets.Alpha = gr.Alpha8bit
-		game.Renderer.RenderPixmap(game.Assets.Sub(392, 453, 406, 480), game.UI.MouseX, game.UI.MouseY-27)
-		game.Assets.Alpha = old
+	selected := game.SelectedCard
+	if (game.State != GameRunning) || (selected == nil) {
+		return
+	}
+	onTable := game.CardOnTable(selected)
+
+	if onTable {
+		for i := 0; i < len(game.FreeCells); i++ {
+			if freecell := &game.FreeCells[i]; freecell.Suit == Blank {
+				game.DrawDestination(game.CardRect(freecell), 1)
+			}
+		}
+	}
+	for i := 0; (i < len(game.Goals)) && (!game.IsGoal(selected)); i++ {
+		if goal := &game.Goals[i]; CanMove2Foundation(selected, goal, game.Rules.Base, game.Rules.Wrap) {
+			game.DrawDestination(game.CardRect(goal), 1)
+		}
+	}
+	for i := 0; i < game.TableColumns; i++ {
+		x := game.ColumnX(i)
+		bottomCard := game.FindBottomCard(&Card{X: int16(x)})
+		if bottomCard == nil {
+			if !game.CanMoveToEmptyColumn(selected) {
+				continue
+			}
+			/* NOTE(anton2920): count follows the option as MoveToEmptyColumn does, so that hint shows what click will move. */
+			count := 1
+			if (onTable) && ((EmptyColumnType(game.EmptyColumnOption) != EmptyColumnCard) || (!game.Rules.CanFillEmpty(selected))) {
+				count = len(game.PowerRunOnTable(selected))
+			}
+			game.DrawDestination(gr.Rect{x, game.TableTop, x + CardWidth - 1, game.TableTop + CardHeight - 1}, count)
+		} else if bottomCard != selected {
+			if run := game.PowerRun(selected, bottomCard); run != nil {
+				game.DrawDestination(game.CardRect(bottomCard), len(run))
+			} else if (!onTable) && (game.Rules.CanBuild(selected, bottomCard)) {
+				game.DrawDestination(game.CardRect(bottomCard), 1)
+			}
+		}
 	}
 }
 
+func (game *FreeCell) DrawDestination(rect gr.Rect, count int) {
+	clr := color.RGB(0xFF, 0xFF, 0)
+	for i := 0; i < 2; i++ {
+		DrawRectWithShadow(game.Renderer, rect.X0+i, rect.Y0+i, rect.X1-i, rect.Y1-i, clr, clr)
+	}
+
+	text := strconv.Itoa(count)
+	textWidth := game.UI.Font.TextWidth(text)
+	textHeight := game.UI.Font.TextHeight(text)
+	x := rect.X1 - textWidth - 6
+	y := rect.Y1 - textHeight - 6
+	game.Renderer.RenderSolidRectWH(x-2, y-1, textWidth+4, textHeight+2, clr)
+	game.Renderer.RenderText(text, game.UI.Font, x, y, color.Black)
+}
+
+/* DrawEmptyColumnDialog asks whether to move the whole run into empty column or only selected card. Nothing else may be done on the table until it is answered. */
+func (game *FreeCell) DrawEmptyColumnDialog() {
+	defer trace.End(trace.Begin(""))
+
+	if !game.Asking {
+		return
+	}
+
+	const width = 360
+	const height = 90
+	const text = "Move column or single card?"
+	x := game.Window.Width/2 - width/2
+	y := game.Window.Height/2 - height/2
+
+	game.Renderer.RenderSolidRectWH(x, y, width, height, color.RGB(0xD4, 0xD0, 0xC8))
+	DrawRectWithShadow(game.Renderer, x, y, x+width-1, y+height-1, color.White, color.Black)
+	game.Renderer.RenderText(text, game.UI.Font, x+width/2-game.UI.Font.TextWidth(text)/2, y+15, color.Black)
+
+	layout := game.UI.Layout
+	game.UI.Layout.CurrentY = y + 45
+
+	game.UI.Layout.CurrentX = x + 10
+	if game.UI.Button(gui.ID(&game.AskColumn), "Move column") {
+		game.PowerMoveOnTable(game.SelectedCard, game.AskColumn, true)
+		game.Asking = false
+	}
+	game.UI.Layout.CurrentX = x + 130
+	if game.UI.Button(gui.ID2(gui.ID(&game.AskColumn)), "Single card") {
+		game.PlaceOnColumn(game.SelectedCard, int16(game.ColumnX(game.AskColumn)), int16(game.TableTop))
+		game.Asking = false
+	}
+	game.UI.Layout.CurrentX = x + 250
+	if game.UI.Button(gui.ID(&game.Asking), "Cancel") {
+		game.Asking = false
+	}
+
+	game.UI.Layout = layout
+	if !game.Asking {
+		game.RemoveSelection()
+		game.SortCards()
+	}
+}
+
+/* DialogOpen reports whether player is asked about empty column, see DrawEmptyColumnDialog. */
+func (game *FreeCell) DialogOpen() bool {
+	return game.Asking
+}
+
+func (game *FreeCell) DrawCursor() {
+	defer trace.End(trace.Begin(""))
+
+	DrawCursor(game.Window, game.Renderer, game.UI, game.Assets, game.Cursor)
+}
+
 func (game *FreeCell) CardRect(card *Card) gr.Rect {
 	defer trace.End(trace.Begin(""))
 
 	return gr.Rect{int(card.X), int(card.Y), int(card.X) + CardWidth - 1, int(card.Y) + CardHeight - 1}
 }
 
+func (game *FreeCell) ColumnX(idx int) int {
+	return game.TableLeft + idx*(game.TableSpace+CardWidth)
+}
+
 func (game *FreeCell) TableColumnRect(idx int) gr.Rect {
 	defer trace.End(trace.Begin(""))
 
-	return gr.Rect{game.TableLeft + idx*(game.TableLeft+CardWidth), game.TableTop, game.TableLeft + idx*(game.TableLeft+CardWidth) + CardWidth - 1, game.Window.Height - 1}
+	return gr.Rect{game.ColumnX(idx), game.TableTop, game.ColumnX(idx) + CardWidth - 1, game.Window.Height - 1}
 }
 
 func (game *FreeCell) HandleFaceInput() {
@@ -449,8 +983,8 @@ func (game *FreeCell) HandleFaceInput() {
 	mouse := gr.Rect{game.UI.MouseX, game.UI.MouseY, game.UI.MouseX, game.UI.MouseY}
 
 	/* Handle face turn. */
-	faceLeftRect := gr.Rect{0, 20, 284, 116}
-	faceRightRect := gr.Rect{348, 20, 632, 116}
+	faceLeftRect := gr.Rect{game.Left, game.PlaceholderTop, game.FaceX - 13, game.PlaceholderTop + game.PlaceholderHeight}
+	faceRightRect := gr.Rect{game.FaceX + 51, game.PlaceholderTop, game.Left + game.Width, game.PlaceholderTop + game.PlaceholderHeight}
 	if faceLeftRect.Contains(mouse) {
 		game.FaceDirection = 0
 	} else if faceRightRect.Contains(mouse) {
@@ -471,15 +1005,15 @@ func (game *FreeCell) HandleCardsInput() {
 
 		if over {
 			if (pressed) && (game.SelectedCard == nil) && (freecell.Suit != Blank) {
-				game.SetSelectedCard(freecell)
+				if (game.SmartMoveOption == 0) || (!game.SmartMove(freecell)) {
+					game.SetSelectedCard(freecell)
+				}
 			} else if (pressed) && (game.SelectedCard == freecell) {
 				game.RemoveSelection()
-			} else if (game.SelectedCard != nil) && (freecell.Suit == Blank) {
+			} else if (game.SelectedCard != nil) && (freecell.Suit == Blank) && (!game.IsGoal(game.SelectedCard)) {
 				game.Cursor = CursorUp
 				if pressed {
-					game.MoveCard(game.SelectedCard, freecell)
-					game.RemoveFromFreecell(game.SelectedCard)
-					game.RemoveFromTable(game.SelectedCard)
+					game.PutCard(game.SelectedCard, freecell)
 					game.RemoveSelection()
 				}
 			}
@@ -491,12 +1025,14 @@ func (game *FreeCell) HandleCardsInput() {
 		over := game.CardRect(goal).Contains(mouse)
 		pressed := game.UI.ButtonLogicDown(gui.ID(goal), over)
 
-		if (over) && (game.SelectedCard != nil) && (CanMove2Goal(game.SelectedCard, goal)) {
+		if (pressed) && (game.SelectedCard == nil) && (goal.Suit != Blank) && (game.Rules.FromFoundation) {
+			game.SetSelectedCard(goal)
+		} else if (pressed) && (game.SelectedCard == goal) {
+			game.RemoveSelection()
+		} else if (over) && (game.SelectedCard != nil) && (!game.IsGoal(game.SelectedCard)) && (CanMove2Foundation(game.SelectedCard, goal, game.Rules.Base, game.Rules.Wrap)) {
 			game.Cursor = CursorUp
 			if pressed {
-				game.MoveCard(game.SelectedCard, goal)
-				game.RemoveFromFreecell(game.SelectedCard)
-				game.RemoveFromTable(game.SelectedCard)
+				game.PutCard(game.SelectedCard, goal)
 				game.RemoveSelection()
 			}
 		}
@@ -514,7 +1050,9 @@ func (game *FreeCell) HandleCardsInput() {
 		pressed := game.UI.ButtonLogicDown(gui.ID(uintptr(game.TableLeft+i)), over)
 
 		if (pressed) && (game.SelectedCard == nil) {
-			game.SetSelectedCard(bottomCard)
+			if (game.SmartMoveOption == 0) || (!game.SmartMove(bottomCard)) {
+				game.SetSelectedCard(bottomCard)
+			}
 		} else if (pressed) && (game.SelectedCard == bottomCard) {
 			game.RemoveSelection()
 		} else if (over) && (game.SelectedCard != nil) {
@@ -524,94 +1062,171 @@ func (game *FreeCell) HandleCardsInput() {
 					if pressed {
 						game.RemoveSelection()
 					}
-				} else if CanMove(game.SelectedCard, bottomCard) {
+				} else if game.Rules.CanBuild(game.SelectedCard, bottomCard) {
 					game.Cursor = CursorDown
 					if pressed {
-						game.SelectedCard.Selected = false
-						game.SelectedCard.X = bottomCard.X
-						game.SelectedCard.Y = bottomCard.Y + CardYPadding
-						if !game.CardOnTable(game.SelectedCard) {
-							game.Table = append(game.Table, *game.SelectedCard)
-							game.RemoveFromFreecell(game.SelectedCard)
-						}
+						game.PlaceOnColumn(game.SelectedCard, bottomCard.X, bottomCard.Y+int16(CardYPadding))
 						game.RemoveSelection()
 					}
 				}
-			} else {
-				if game.PowerMoveOnTable(game.SelectedCard, i, pressed) {
-					game.Cursor = CursorDown
-					if pressed {
-						game.RemoveSelection()
-					}
-				} else {
-					game.Cursor = CursorDown
-					if pressed {
-						game.SelectedCard.Selected = false
-						game.SelectedCard.X = int16(columnRect.X0)
-						game.SelectedCard.Y = int16(columnRect.Y0)
-						if !game.CardOnTable(game.SelectedCard) {
-							game.Table = append(game.Table, *game.SelectedCard)
-							game.RemoveFromFreecell(game.SelectedCard)
-						}
-						game.RemoveSelection()
-					}
+			} else if game.CanMoveToEmptyColumn(game.SelectedCard) {
+				game.Cursor = CursorDown
+				if pressed {
+					game.MoveToEmptyColumn(game.SelectedCard, i)
 				}
 			}
 		}
 	}
 }
 
-func (game *FreeCell) RemoveCardIfUseless(card *Card) bool {
+/* IsSafeHome checks card against foundations of the game by the same rule solver uses. */
+func (game *FreeCell) IsSafeHome(card *Card) bool {
+	var foundations [Aces + 1]ValueType
+	for i := 0; i < len(game.Goals); i++ {
+		if goal := &game.Goals[i]; goal.Suit != Blank {
+			foundations[goal.Suit] = goal.Value
+		}
+	}
+	return IsSafeHome(game.Rules, &foundations, card)
+}
+
+/* AutoplayCard sends card to foundation if policy allows it. Card which player has just taken from foundation is left alone. */
+func (game *FreeCell) AutoplayCard(card *Card, policy AutoplayType) bool {
 	defer trace.End(trace.Begin(""))
 
-	if (card != nil) && (card.Suit != Blank) {
-		useless := true
+	if (card == nil) || (card.Suit == Blank) || ((card.Suit == game.Returned.Suit) && (card.Value == game.Returned.Value)) {
+		return false
+	}
+	if (policy == AutoplaySafe) && (!game.IsSafeHome(card)) {
+		return false
+	}
+
+	for i := 0; i < len(game.Goals); i++ {
+		goal := &game.Goals[i]
 
-		for i := 0; i < len(game.Table); i++ {
-			if (game.Table[i].Value >= Two) && (CanMove(&game.Table[i], card)) {
-				useless = false
-				break
-			}
+		if CanMove2Foundation(card, goal, game.Rules.Base, game.Rules.Wrap) {
+			game.MoveCard(card, goal)
+			game.RemoveFromFreecell(card)
+			game.RemoveFromTable(card)
+			return true
+		}
+	}
+	return false
+}
+
+/* Autoplay sends cards to foundations according to policy chosen in options. Nothing is moved while card is selected. */
+func (game *FreeCell) Autoplay() {
+	defer trace.End(trace.Begin(""))
+
+	policy := AutoplayType(game.AutoplayOption)
+	if (policy == AutoplayOff) || (game.SelectedCard != nil) {
+		return
+	}
+
+	for moved := true; moved; {
+		moved = false
+		for i := 0; i < game.TableColumns; i++ {
+			card := game.FindBottomCard(&Card{X: int16(game.ColumnX(i))})
+			moved = game.AutoplayCard(card, policy) || moved
 		}
+
 		for i := 0; i < len(game.FreeCells); i++ {
-			if (game.FreeCells[i].Value >= Two) && (CanMove(&game.FreeCells[i], card)) {
-				useless = false
-				break
-			}
+			moved = game.AutoplayCard(&game.FreeCells[i], policy) || moved
 		}
-		if useless {
-			for i := 0; i < len(game.Goals); i++ {
-				goal := &game.Goals[i]
+	}
+}
 
-				if CanMove2Goal(card, goal) {
-					game.MoveCard(card, goal)
-					game.RemoveFromFreecell(card)
-					game.RemoveFromTable(card)
-					return true
-				}
+/* CanFinish reports whether every column is in descending order, so that all cards may go to foundations one by one. */
+func (game *FreeCell) CanFinish() bool {
+	defer trace.End(trace.Begin(""))
+
+	if (game.State != GameRunning) || (game.Finishing) || (game.Asking) || (game.Won()) {
+		return false
+	}
+
+	/* NOTE(anton2920): table is sorted by Y, see SortCards. */
+	for i := 0; i < game.TableColumns; i++ {
+		x := int16(game.ColumnX(i))
+		var prev *Card
+		for j := 0; j < len(game.Table); j++ {
+			card := &game.Table[j]
+			if card.X != x {
+				continue
 			}
+			if (prev != nil) && (card.Value > prev.Value) {
+				return false
+			}
+			prev = card
 		}
 	}
+	return true
+}
 
-	return false
+func (game *FreeCell) Finish() {
+	if game.CanFinish() {
+		game.RemoveSelection()
+		game.Finishing = true
+	}
 }
 
-func (game *FreeCell) Autoplay() {
+/* FinishNextCard lands card which is flying and sends the lowest of remaining cards after it. */
+func (game *FreeCell) FinishNextCard() {
 	defer trace.End(trace.Begin(""))
 
-	removed := true && game.AutoplayAllowed
-	for removed {
-		removed = false
-		for i := 0; i < game.TableColumns; i++ {
-			columnRect := game.TableColumnRect(i)
-			card := game.FindBottomCard(&Card{X: int16(columnRect.X0), Y: int16(columnRect.Y0)})
-			removed = game.RemoveCardIfUseless(card) || removed
+	if game.Flying.Suit != Blank {
+		if time.Since(game.FlyingStart) < FinishMoveTime {
+			return
+		}
+		game.MoveCard(&game.Flying, &game.Goals[game.FlyingGoal])
+		game.Flying = Card{}
+	}
+
+	var next *Card
+	for i := 0; i < game.TableColumns+len(game.FreeCells); i++ {
+		var card *Card
+		if i < game.TableColumns {
+			card = game.FindBottomCard(&Card{X: int16(game.ColumnX(i))})
+		} else {
+			card = &game.FreeCells[i-game.TableColumns]
 		}
+		if (card != nil) && (card.Suit != Blank) && ((next == nil) || (card.Value < next.Value)) {
+			next = card
+		}
+	}
 
-		for i := 0; i < len(game.FreeCells); i++ {
-			removed = game.RemoveCardIfUseless(&game.FreeCells[i]) || removed
+	goal := -1
+	for i := 0; (i < len(game.Goals)) && (next != nil); i++ {
+		if CanMove2Foundation(next, &game.Goals[i], game.Rules.Base, game.Rules.Wrap) {
+			goal = i
+			break
 		}
 	}
+	if goal == -1 {
+		game.Finishing = false
+		return
+	}
+
+	game.Flying = *next
+	game.FlyingGoal = goal
+	game.FlyingStart = time.Now()
+	game.RemoveFromFreecell(next)
+	game.RemoveFromTable(next)
+}
+
+/* DrawFlyingCard draws card on its way from the table to foundation. */
+func (game *FreeCell) DrawFlyingCard() {
+	defer trace.End(trace.Begin(""))
+
+	if game.Flying.Suit == Blank {
+		return
+	}
+
+	t := min(float32(time.Since(game.FlyingStart))/float32(FinishMoveTime), 1)
+	goal := &game.Goals[game.FlyingGoal]
+	card := game.Flying
+	card.X += int16(t * float32(goal.X-card.X))
+	card.Y += int16(t * float32(goal.Y-card.Y))
+	game.DrawCard(&card)
 }
 
 func (game *FreeCell) SortCards() {
@@ -626,7 +1241,33 @@ func (game *FreeCell) SortCards() {
 	}
 }
 
-func (game *FreeCell) GameWon() bool {
+func (game *FreeCell) Name() string {
+	return game.Rules.Name
+}
+
+/* DifficultyAnyOption is the value of DifficultyOption which allows deals solver does not win. */
+const DifficultyAnyOption = int(DifficultyExpert) + 2
+
+func (game *FreeCell) Options() []Option {
+	if game.OptionList == nil {
+		values := []string{"any winnable"}
+		for d := DifficultyEasy; d <= DifficultyExpert; d++ {
+			values = append(values, d.String())
+		}
+		values = append(values, "any, even unwinnable")
+
+		game.OptionList = append(game.OptionList,
+			Option{Name: "Deals", Values: values, Value: &game.DifficultyOption},
+			Option{Name: "Click", Values: []string{"selects card", "moves card to best place"}, Value: &game.SmartMoveOption},
+			Option{Name: "Best place", Values: SmartMoveOrderNames, Value: &game.SmartOrderOption},
+			Option{Name: "Run to empty column", Values: []string{"ask", "move column", "move one card"}, Value: &game.EmptyColumnOption},
+			Option{Name: "Autoplay", Values: AutoplayNames[:], Value: &game.AutoplayOption},
+			Option{Name: "Finish won game", Values: []string{"on click", "automatically"}, Value: &game.FinishOption})
+	}
+	return game.OptionList
+}
+
+func (game *FreeCell) Won() bool {
 	defer trace.End(trace.Begin(""))
 
 	var kings int
@@ -638,9 +1279,282 @@ func (game *FreeCell) GameWon() bool {
 	return kings == 4
 }
 
-func (game *FreeCell) UpdateAndRender() {
+func (game *FreeCell) CardsHome() int {
+	var cards int
+	for i := 0; i < len(game.Goals); i++ {
+		cards += int(game.Goals[i].Value)
+	}
+	return cards
+}
+
+func (game *FreeCell) DealNumber() int {
+	return game.Number
+}
+
+func (game *FreeCell) Restart() {
+	game.Deal(game.Number)
+}
+
+func (game *FreeCell) RaceJob() RaceJob {
+	pos := NewFreeCellPosition(game)
+	return func(stop *atomic.Bool) RaceRecord {
+		var record RaceRecord
+
+		record.Solution = SolveFreeCellPosition(pos, DefaultSolveLimit, stop)
+		for i := 0; i <= len(record.Solution.Moves); i++ {
+			record.Boards = append(record.Boards, pos.RaceBoard())
+			if i < len(record.Solution.Moves) {
+				pos.MakeMove(record.Solution.Moves[i])
+			}
+		}
+		return record
+	}
+}
+
+/* BeginMove remembers the game before player moves card, so that EndMove may record the move in History. */
+func (game *FreeCell) BeginMove(card *Card) {
+	defer trace.End(trace.Begin(""))
+
+	game.History.Begin(game)
+	game.MoveFrom = game.PileName(card)
+}
+
+/* EndMove records move which BeginMove has started in History. to is name of pile the card has been moved to. */
+func (game *FreeCell) EndMove(to string) {
+	game.History.End(game.MoveFrom + to)
+}
+
+/* PileName names pile which card lies on in move notation: free cells are lettered from 'a', foundations are 'h' and columns are numbered from 1. */
+func (game *FreeCell) PileName(card *Card) string {
+	if game.IsGoal(card) {
+		return "h"
+	}
+	for i := 0; i < len(game.FreeCells); i++ {
+		if &game.FreeCells[i] == card {
+			return string(rune('a' + i))
+		}
+	}
+	return ColumnName(game.ColumnAt(card.X))
+}
+
+/* ColumnAt returns index of column which starts at x. */
+func (game *FreeCell) ColumnAt(x int16) int {
+	for i := 0; i < game.TableColumns; i++ {
+		if int(x) == game.ColumnX(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func ColumnName(i int) string {
+	return strconv.Itoa(i + 1)
+}
+
+func (game *FreeCell) CanUndo() bool {
+	return (game.State == GameRunning) && (!game.Finishing) && (!game.Asking) && (len(game.History.Entries) > 0)
+}
+
+func (game *FreeCell) Undo() error {
+	if !game.CanUndo() {
+		return nil
+	}
+	return game.History.Undo(game)
+}
+
+func (game *FreeCell) LastMove() string {
+	return game.History.LastMove()
+}
+
+/* Lost reports whether there are no moves left. */
+func (game *FreeCell) Lost() bool {
+	defer trace.End(trace.Begin(""))
+
+	if (game.State != GameRunning) || (len(game.Table) == 0) {
+		return false
+	}
+
+	srcs := make([]*Card, 0, game.TableColumns+len(game.FreeCells))
+	dsts := make([]*Card, 0, game.TableColumns)
+	for i := 0; i < len(game.FreeCells); i++ {
+		if game.FreeCells[i].Suit == Blank {
+			return false
+		}
+		srcs = append(srcs, &game.FreeCells[i])
+	}
+	for i := 0; i < game.TableColumns; i++ {
+		columnRect := game.TableColumnRect(i)
+		bottomCard := game.FindBottomCard(&Card{X: int16(columnRect.X0), Y: int16(columnRect.Y0)})
+		if bottomCard == nil {
+			return false
+		}
+		srcs = append(srcs, bottomCard)
+		dsts = append(dsts, bottomCard)
+	}
+
+	for i := 0; (i < len(game.Goals)) && (game.Rules.FromFoundation); i++ {
+		for _, dst := range dsts {
+			if (game.Goals[i].Suit != Blank) && (game.Rules.CanBuild(&game.Goals[i], dst)) {
+				return false
+			}
+		}
+	}
+	for _, src := range srcs {
+		for i := 0; i < len(game.Goals); i++ {
+			if CanMove2Foundation(src, &game.Goals[i], game.Rules.Base, game.Rules.Wrap) {
+				return false
+			}
+		}
+		for _, dst := range dsts {
+			if game.Rules.CanBuild(src, dst) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/* Save writes the table. Card which flies to foundation during auto-finish is saved as landed there. */
+func (game *FreeCell) Save(w io.Writer) error {
+	fmt.Fprintf(w, "deal %d\n", game.Number)
+	fmt.Fprintf(w, "autoplay %s\n", AutoplayNames[game.AutoplayOption])
+
+	column := make([]Card, 0, 52)
+	for i := 0; i < game.TableColumns; i++ {
+		x := int16(game.ColumnX(i))
+
+		/* NOTE(anton2920): table is sorted by Y, see SortCards. */
+		column = column[:0]
+		for j := 0; j < len(game.Table); j++ {
+			if game.Table[j].X == x {
+				column = append(column, game.Table[j])
+			}
+		}
+		WriteCards(w, "column", column)
+	}
+	WriteCards(w, "freecells", game.FreeCells[:])
+
+	goals := append([]Card(nil), game.Goals...)
+	if game.Flying.Suit != Blank {
+		goals[game.FlyingGoal] = game.Flying
+	}
+	WriteCards(w, "goals", goals)
+
+	if game.Returned.Suit != Blank {
+		WriteCards(w, "returned", []Card{game.Returned})
+	}
+
+	return nil
+}
+
+/* Load reads the whole save before it changes the table, so that game is left as it was if save is broken. */
+func (game *FreeCell) Load(r io.Reader) error {
+	var number, autoplay int
+	var columns [][]Card
+	var freecells, goals []Card
+	var returned Card
+
+	autoplay = game.AutoplayOption
+	err := ReadSave(r, func(key string, values []string) error {
+		var err error
+
+		switch key {
+		default:
+			return fmt.Errorf("unknown key %q", key)
+		case "deal":
+			number, err = ParseInt(values)
+			return err
+		case "autoplay":
+			autoplay, err = ParseWord(values, AutoplayNames[:]...)
+			return err
+		case "column":
+			if len(columns) >= game.TableColumns {
+				return fmt.Errorf("too many columns")
+			}
+			cards, err := ParseCards(values)
+			if err != nil {
+				return err
+			}
+			columns = append(columns, cards)
+		case "returned":
+			cards, err := ParseCards(values)
+			if err != nil {
+				return err
+			}
+			if len(cards) != 1 {
+				return fmt.Errorf("expected one returned card, got %d", len(cards))
+			}
+			returned = cards[0]
+		case "freecells", "goals":
+			expected := len(game.FreeCells)
+			if key == "goals" {
+				expected = len(game.Goals)
+			}
+			cards, err := ParseCards(values)
+			if err != nil {
+				return err
+			}
+			if len(cards) != expected {
+				return fmt.Errorf("expected %d %s, got %d", expected, key, len(cards))
+			}
+			if key == "goals" {
+				goals = cards
+			} else {
+				freecells = cards
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(columns) != game.TableColumns {
+		return fmt.Errorf("expected %d columns, got %d", game.TableColumns, len(columns))
+	}
+
+	game.CancelSearch()
+	game.Asking = false
+	game.Finishing = false
+	game.Flying = Card{}
+	game.Returned = returned
+	game.History.Clear()
+	game.RemoveSelection()
+	game.Table = game.Table[:0]
+	game.ClearPlaceholders()
+
+	game.Number = number
+	game.AutoplayOption = autoplay
+	for i := 0; i < len(columns); i++ {
+		for j := 0; j < len(columns[i]); j++ {
+			game.PlaceOnTable(&columns[i][j], i, j)
+		}
+		game.Table = append(game.Table, columns[i]...)
+	}
+	for i := 0; i < len(freecells); i++ {
+		game.FreeCells[i].Value = freecells[i].Value
+		game.FreeCells[i].Suit = freecells[i].Suit
+	}
+	for i := 0; i < len(goals); i++ {
+		game.Goals[i].Value = goals[i].Value
+		game.Goals[i].Suit = goals[i].Suit
+	}
+	game.SortCards()
+
+	SetGameTitle(game.Window, game.Rules.Name, game.Number)
+	game.State = GameRunning
+	if game.Won() {
+		game.State = GameEnd
+	}
+
+	return nil
+}
+
+func (game *FreeCell) Update() {
 	defer trace.End(trace.Begin(""))
 
+	if (game.Window.Width != game.LayoutWidth) || (CardScale != game.LayoutScale) {
+		game.Resize(game.Window.Width)
+	}
 	game.HandleFaceInput()
 
 	if game.UI.MiddleDown {
@@ -658,25 +1572,50 @@ func (game *FreeCell) UpdateAndRender() {
 		}
 	}
 
+	if game.Search != nil {
+		if N, ok := game.Search.Poll(); ok {
+			game.Search = nil
+			game.Deal(N)
+		}
+	}
+
 	if game.State == GameRunning {
-		game.HandleCardsInput()
-		game.Autoplay()
+		if game.Finishing {
+			game.FinishNextCard()
+		} else if !game.Asking {
+			game.HandleCardsInput()
+			game.Autoplay()
+			if (game.FinishOption == 1) && (game.CanFinish()) {
+				game.Finish()
+			}
+		}
 		game.SortCards()
 
-		if game.GameWon() {
+		if game.Won() {
 			game.State = GameEnd
 			game.Cursor = CursorDefault
 			game.UI.ClearActive()
 		}
 	}
+	game.LayoutColumns()
+}
+
+func (game *FreeCell) Render() {
+	defer trace.End(trace.Begin(""))
 
 	game.DrawBackground()
 	game.DrawCards()
+	game.DrawDestinations()
+	game.DrawFlyingCard()
 
 	game.DrawFace()
 	if game.State == GameEnd {
 		game.DrawGiantFace()
 	}
+	if game.Search != nil {
+		DrawDealSearch(game.Window, game.Renderer, game.UI)
+	}
 	game.DrawCursor()
 	game.DrawMenu()
+	game.DrawEmptyColumnDialog()
 }
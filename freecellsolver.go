@@ -0,0 +1,756 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"sync/atomic"
+
+	"github.com/anton2920/gofa/trace"
+)
+
+/* FreeCellPosition is a compact copy of FreeCell table which solver plays on. Empty cells hold blank cards. */
+type FreeCellPosition struct {
+	Rules *Rules
+
+	Columns     [][]Card
+	Cells       []Card
+	Foundations [Aces + 1]ValueType
+
+	/* Prune skips moves which are rarely needed, see IsPointless. */
+	Prune bool
+}
+
+/* NOTE(anton2920): in moves of FreeCell solver piles are numbered as follows: cells, foundations in order of suits, columns. */
+const FreeCellFoundations = 4
+
+/* FreeCellNode is position which best-first search has reached, with moves which lead to it from its parent. */
+type FreeCellNode struct {
+	Position []byte
+	Parent   int
+	Moves    []Move
+}
+
+type FreeCellQueueItem struct {
+	Score int
+	Node  int
+}
+
+type FreeCellSolver struct {
+	Limit int
+	Nodes int
+
+	/* Stop is set by other goroutine when it is no longer interested in result. It may be nil. */
+	Stop *atomic.Bool
+
+	Position FreeCellPosition
+	Visited  map[uint64]struct{}
+	Moves    []Move
+	Counts   []int
+
+	Tree  []FreeCellNode
+	Queue []FreeCellQueueItem
+
+	Seed      maphash.Seed
+	Hashes    []uint64
+	KeyBuffer []byte
+	Scores    []int
+}
+
+/* NewFreeCellPosition takes cards from the table of the game. */
+func NewFreeCellPosition(game *FreeCell) FreeCellPosition {
+	var pos FreeCellPosition
+
+	pos.Rules = game.Rules
+	pos.Columns = make([][]Card, game.TableColumns)
+	for i := 0; i < game.TableColumns; i++ {
+		x := int16(game.ColumnX(i))
+		pos.Columns[i] = make([]Card, 0, 52)
+
+		/* NOTE(anton2920): table is sorted by Y, see SortCards. */
+		for j := 0; j < len(game.Table); j++ {
+			if game.Table[j].X == x {
+				pos.Columns[i] = append(pos.Columns[i], Card{Value: game.Table[j].Value, Suit: game.Table[j].Suit})
+			}
+		}
+	}
+
+	pos.Cells = make([]Card, len(game.FreeCells))
+	for i := 0; i < len(game.FreeCells); i++ {
+		pos.Cells[i] = Card{Value: game.FreeCells[i].Value, Suit: game.FreeCells[i].Suit}
+	}
+	for i := 0; i < len(game.Goals); i++ {
+		if goal := &game.Goals[i]; goal.Suit != Blank {
+			pos.Foundations[goal.Suit] = goal.Value
+		}
+	}
+
+	return pos
+}
+
+/* DealFreeCellPosition deals cards of deal N as FreeCell.Deal does. Cards are not laid out on the table, so it may be called from any goroutine. */
+func DealFreeCellPosition(rules *Rules, N int) FreeCellPosition {
+	var pos FreeCellPosition
+
+	pos.Rules = rules
+	pos.Columns = make([][]Card, rules.Columns)
+	for i := 0; i < len(pos.Columns); i++ {
+		pos.Columns[i] = make([]Card, 0, 52)
+	}
+	pos.Cells = make([]Card, rules.Cells)
+
+	deck := ShuffleFreeCell(make([]Card, 0, 52), N)
+	for k := 0; k < len(deck); k++ {
+		column := &pos.Columns[k%rules.Columns]
+		*column = append(*column, deck[k])
+	}
+
+	return pos
+}
+
+/* RaceBoard puts free cells and foundations into top row of the board, followed by columns. */
+func (pos *FreeCellPosition) RaceBoard() RaceBoard {
+	var board RaceBoard
+
+	board.Top = append(board.Top, pos.Cells...)
+	for suit := Clubs; suit <= Aces; suit++ {
+		var card Card
+		if pos.Foundations[suit] != None {
+			card = Card{Value: pos.Foundations[suit], Suit: suit}
+		}
+		board.Top = append(board.Top, card)
+		board.Home += int(pos.Foundations[suit])
+	}
+
+	board.Columns = make([][]Card, len(pos.Columns))
+	for i := 0; i < len(pos.Columns); i++ {
+		board.Columns[i] = append([]Card(nil), pos.Columns[i]...)
+	}
+	return board
+}
+
+/* AppendPosition appends cards of the game in form which FreeCellPosition.LoadPosition reads. */
+func (game *FreeCell) AppendPosition(buffer []byte) []byte {
+	pos := NewFreeCellPosition(game)
+	return pos.AppendPosition(buffer)
+}
+
+/* SolveJob solves position of cards which game has now, so that it may be played while job runs. */
+func (game *FreeCell) SolveJob() SolveJob {
+	pos := NewFreeCellPosition(game)
+	return func(stop *atomic.Bool, limit int) Solution {
+		return SolveFreeCellPosition(pos, limit, stop)
+	}
+}
+
+/* Clone returns copy of the position which does not share cards with it. */
+func (pos *FreeCellPosition) Clone() FreeCellPosition {
+	clone := *pos
+
+	clone.Columns = make([][]Card, len(pos.Columns))
+	for i := 0; i < len(pos.Columns); i++ {
+		clone.Columns[i] = append(make([]Card, 0, 52), pos.Columns[i]...)
+	}
+	clone.Cells = append([]Card(nil), pos.Cells...)
+
+	return clone
+}
+
+func (pos *FreeCellPosition) Won() bool {
+	for suit := Clubs; suit <= Aces; suit++ {
+		if pos.Foundations[suit] != King {
+			return false
+		}
+	}
+	return true
+}
+
+func (pos *FreeCellPosition) FoundationID(suit SuitType) int {
+	return len(pos.Cells) + int(suit-Clubs)
+}
+
+func (pos *FreeCellPosition) ColumnID(i int) int {
+	return len(pos.Cells) + FreeCellFoundations + i
+}
+
+/* AllowedToMove returns how many cards may be moved at once to non-empty column, or to empty one when empty is set. */
+func (pos *FreeCellPosition) AllowedToMove(empty bool) int {
+	if !pos.Rules.Supermove {
+		return 1
+	}
+
+	var cells, columns uint
+	for i := 0; i < len(pos.Cells); i++ {
+		if pos.Cells[i].Suit == Blank {
+			cells++
+		}
+	}
+	for i := 0; i < len(pos.Columns); i++ {
+		if len(pos.Columns[i]) == 0 {
+			columns++
+		}
+	}
+	if empty {
+		columns--
+	}
+	return int((cells + 1) * (1 << columns))
+}
+
+func (pos *FreeCellPosition) CanMove2Foundation(card *Card) bool {
+	return (card.Suit != Blank) && (pos.Foundations[card.Suit]+1 == card.Value)
+}
+
+/* IsSafe reports whether card going to foundation is not needed on the table to hold other cards. */
+func (pos *FreeCellPosition) IsSafe(card *Card) bool {
+	return IsSafeHome(pos.Rules, &pos.Foundations, card)
+}
+
+/* IsSafeHome reports whether every card which may be built on card is already in foundations, so card is not needed on the table. */
+func IsSafeHome(rules *Rules, foundations *[Aces + 1]ValueType, card *Card) bool {
+	for suit := Clubs; suit <= Aces; suit++ {
+		under := Card{Value: card.Value - 1, Suit: suit}
+		if (under.Value > None) && (rules.CanBuild(&under, card)) && (foundations[suit] < under.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+/* MakeMove applies move to position and returns number of moved cards, which Undo needs to take it back. */
+func (pos *FreeCellPosition) MakeMove(move Move) int {
+	var cards []Card
+
+	switch {
+	case move.Src < len(pos.Cells):
+		cards = []Card{pos.Cells[move.Src]}
+		pos.Cells[move.Src] = Card{}
+	case move.Src < len(pos.Cells)+FreeCellFoundations:
+		suit := SuitType(move.Src-len(pos.Cells)) + Clubs
+		cards = []Card{{Value: pos.Foundations[suit], Suit: suit}}
+		pos.Foundations[suit]--
+	default:
+		i := move.Src - len(pos.Cells) - FreeCellFoundations
+		cards = pos.Columns[i][move.Index:]
+		pos.Columns[i] = pos.Columns[i][:move.Index]
+	}
+
+	switch {
+	case move.Dst < len(pos.Cells):
+		pos.Cells[move.Dst] = cards[0]
+	case move.Dst < len(pos.Cells)+FreeCellFoundations:
+		pos.Foundations[cards[0].Suit]++
+	default:
+		i := move.Dst - len(pos.Cells) - FreeCellFoundations
+		pos.Columns[i] = append(pos.Columns[i], cards...)
+	}
+	return len(cards)
+}
+
+func (pos *FreeCellPosition) Undo(move Move, count int) {
+	var cards []Card
+
+	switch {
+	case move.Dst < len(pos.Cells):
+		cards = []Card{pos.Cells[move.Dst]}
+		pos.Cells[move.Dst] = Card{}
+	case move.Dst < len(pos.Cells)+FreeCellFoundations:
+		suit := SuitType(move.Dst-len(pos.Cells)) + Clubs
+		cards = []Card{{Value: pos.Foundations[suit], Suit: suit}}
+		pos.Foundations[suit]--
+	default:
+		i := move.Dst - len(pos.Cells) - FreeCellFoundations
+		n := len(pos.Columns[i]) - count
+		cards = pos.Columns[i][n:]
+		pos.Columns[i] = pos.Columns[i][:n]
+	}
+
+	switch {
+	case move.Src < len(pos.Cells):
+		pos.Cells[move.Src] = cards[0]
+	case move.Src < len(pos.Cells)+FreeCellFoundations:
+		pos.Foundations[cards[0].Suit]++
+	default:
+		i := move.Src - len(pos.Cells) - FreeCellFoundations
+		pos.Columns[i] = append(pos.Columns[i], cards...)
+	}
+}
+
+/* Moves appends every useful move to moves, better ones first. */
+func (pos *FreeCellPosition) Moves(moves []Move) []Move {
+	defer trace.End(trace.Begin(""))
+
+	rules := pos.Rules
+
+	/* NOTE(anton2920): cells and empty columns are alike, so only first of them is tried. */
+	freeCell, emptyColumn := -1, -1
+	for i := len(pos.Cells) - 1; i >= 0; i-- {
+		if pos.Cells[i].Suit == Blank {
+			freeCell = i
+		}
+	}
+	for i := len(pos.Columns) - 1; i >= 0; i-- {
+		if len(pos.Columns[i]) == 0 {
+			emptyColumn = i
+		}
+	}
+
+	for i := 0; i < len(pos.Cells); i++ {
+		if card := &pos.Cells[i]; pos.CanMove2Foundation(card) {
+			moves = append(moves, Move{Src: i, Dst: pos.FoundationID(card.Suit)})
+		}
+	}
+	for i := 0; i < len(pos.Columns); i++ {
+		column := pos.Columns[i]
+		if (len(column) > 0) && (pos.CanMove2Foundation(&column[len(column)-1])) {
+			moves = append(moves, Move{Src: pos.ColumnID(i), Dst: pos.FoundationID(column[len(column)-1].Suit), Index: len(column) - 1})
+		}
+	}
+
+	allowed := pos.AllowedToMove(false)
+	for i := 0; i < len(pos.Columns); i++ {
+		src := pos.Columns[i]
+		for j := 0; j < len(pos.Columns); j++ {
+			dst := pos.Columns[j]
+			if (i == j) || (len(dst) == 0) {
+				continue
+			}
+			for k := len(src) - 1; (k >= 0) && (len(src)-k <= allowed); k-- {
+				if (rules.CanBuild(&src[k], &dst[len(dst)-1])) && ((!pos.Prune) || (!pos.IsPointless(src, k))) {
+					moves = append(moves, Move{Src: pos.ColumnID(i), Dst: pos.ColumnID(j), Index: k})
+				}
+				if (k > 0) && (!rules.CanBuild(&src[k], &src[k-1])) {
+					break
+				}
+			}
+		}
+	}
+	for i := 0; i < len(pos.Cells); i++ {
+		card := &pos.Cells[i]
+		if card.Suit == Blank {
+			continue
+		}
+		for j := 0; j < len(pos.Columns); j++ {
+			if dst := pos.Columns[j]; (len(dst) > 0) && (rules.CanBuild(card, &dst[len(dst)-1])) {
+				moves = append(moves, Move{Src: i, Dst: pos.ColumnID(j)})
+			}
+		}
+	}
+
+	if (emptyColumn >= 0) && (rules.Empty != EmptyNone) {
+		allowed := pos.AllowedToMove(true)
+		for i := 0; i < len(pos.Columns); i++ {
+			src := pos.Columns[i]
+			for k := len(src) - 1; (k > 0) && (len(src)-k <= allowed); k-- {
+				if rules.CanFillEmpty(&src[k]) {
+					moves = append(moves, Move{Src: pos.ColumnID(i), Dst: pos.ColumnID(emptyColumn), Index: k})
+				}
+				if !rules.CanBuild(&src[k], &src[k-1]) {
+					break
+				}
+			}
+		}
+		for i := 0; i < len(pos.Cells); i++ {
+			if card := &pos.Cells[i]; (card.Suit != Blank) && (rules.CanFillEmpty(card)) {
+				moves = append(moves, Move{Src: i, Dst: pos.ColumnID(emptyColumn)})
+			}
+		}
+	}
+
+	if freeCell >= 0 {
+		for i := 0; i < len(pos.Columns); i++ {
+			if src := pos.Columns[i]; len(src) > 0 {
+				moves = append(moves, Move{Src: pos.ColumnID(i), Dst: freeCell, Index: len(src) - 1})
+			}
+		}
+	}
+
+	return moves
+}
+
+/* IsPointless reports whether cards of column starting from k sit on proper card, and the card beneath them can not go to foundation. */
+func (pos *FreeCellPosition) IsPointless(column []Card, k int) bool {
+	return (k > 0) && (pos.Rules.CanBuild(&column[k], &column[k-1])) && (!pos.CanMove2Foundation(&column[k-1]))
+}
+
+/* Score is higher for positions which are closer to win. */
+func (pos *FreeCellPosition) Score() int {
+	var score int
+
+	for suit := Clubs; suit <= Aces; suit++ {
+		score += 10 * int(pos.Foundations[suit])
+	}
+	for i := 0; i < len(pos.Cells); i++ {
+		if pos.Cells[i].Suit == Blank {
+			score += 4
+		}
+	}
+	for i := 0; i < len(pos.Columns); i++ {
+		column := pos.Columns[i]
+		if len(column) == 0 {
+			score += 8
+		}
+		for j := 0; j < len(column); j++ {
+			/* NOTE(anton2920): cards which are needed next should not be buried. */
+			if pos.Foundations[column[j].Suit]+1 == column[j].Value {
+				score -= 3 * (len(column) - j - 1)
+			}
+			if (j > 0) && (!pos.Rules.CanBuild(&column[j], &column[j-1])) {
+				score--
+			}
+		}
+	}
+
+	return score
+}
+
+/* AppendPosition appends position to buffer in form which LoadPosition reads. */
+func (pos *FreeCellPosition) AppendPosition(buffer []byte) []byte {
+	for i := 0; i < len(pos.Columns); i++ {
+		for j := 0; j < len(pos.Columns[i]); j++ {
+			buffer = append(buffer, CardByte(&pos.Columns[i][j]))
+		}
+		buffer = append(buffer, 0)
+	}
+	for i := 0; i < len(pos.Cells); i++ {
+		buffer = append(buffer, CardByte(&pos.Cells[i]))
+	}
+	for suit := Clubs; suit <= Aces; suit++ {
+		buffer = append(buffer, byte(pos.Foundations[suit]))
+	}
+	return buffer
+}
+
+func (pos *FreeCellPosition) LoadPosition(buffer []byte) {
+	for i := 0; i < len(pos.Columns); i++ {
+		pos.Columns[i] = pos.Columns[i][:0]
+		for ; buffer[0] != 0; buffer = buffer[1:] {
+			pos.Columns[i] = append(pos.Columns[i], ByteCard(buffer[0]))
+		}
+		buffer = buffer[1:]
+	}
+	for i := 0; i < len(pos.Cells); i++ {
+		pos.Cells[i] = ByteCard(buffer[i])
+	}
+	buffer = buffer[len(pos.Cells):]
+	for suit := Clubs; suit <= Aces; suit++ {
+		pos.Foundations[suit] = ValueType(buffer[suit-Clubs])
+	}
+}
+
+func CardByte(card *Card) byte {
+	return byte(card.Suit)<<4 | byte(card.Value)
+}
+
+func ByteCard(b byte) Card {
+	return Card{Value: ValueType(b & 0xF), Suit: SuitType(b >> 4)}
+}
+
+/* SafeMove returns move to foundation of card which is not needed on the table. */
+func (pos *FreeCellPosition) SafeMove() (Move, bool) {
+	for i := 0; i < len(pos.Cells); i++ {
+		if card := &pos.Cells[i]; (pos.CanMove2Foundation(card)) && (pos.IsSafe(card)) {
+			return Move{Src: i, Dst: pos.FoundationID(card.Suit)}, true
+		}
+	}
+	for i := 0; i < len(pos.Columns); i++ {
+		column := pos.Columns[i]
+		if len(column) == 0 {
+			continue
+		}
+		if card := &column[len(column)-1]; (pos.CanMove2Foundation(card)) && (pos.IsSafe(card)) {
+			return Move{Src: pos.ColumnID(i), Dst: pos.FoundationID(card.Suit), Index: len(column) - 1}, true
+		}
+	}
+	return Move{}, false
+}
+
+/* Key identifies position regardless of order of columns and cells. */
+func (solver *FreeCellSolver) Key() uint64 {
+	var buffer [2 * 52]byte
+
+	pos := &solver.Position
+	hashes := solver.Hashes[:0]
+	for i := 0; i < len(pos.Columns); i++ {
+		column := buffer[:0]
+		for j := 0; j < len(pos.Columns[i]); j++ {
+			column = AppendCard(column, &pos.Columns[i][j])
+		}
+		hashes = append(hashes, maphash.Bytes(solver.Seed, column))
+	}
+	for i := 0; i < len(pos.Cells); i++ {
+		hashes = append(hashes, uint64(pos.Cells[i].Suit)<<8|uint64(pos.Cells[i].Value))
+	}
+	SortHashes(hashes[:len(pos.Columns)])
+	SortHashes(hashes[len(pos.Columns):])
+	solver.Hashes = hashes
+
+	key := solver.KeyBuffer[:0]
+	for i := 0; i < len(hashes); i++ {
+		key = binary.LittleEndian.AppendUint64(key, hashes[i])
+	}
+	solver.KeyBuffer = key
+	return maphash.Bytes(solver.Seed, key)
+}
+
+/* SortMoves puts moves which lead to better positions first. */
+func (solver *FreeCellSolver) SortMoves(moves []Move) {
+	pos := &solver.Position
+
+	scores := solver.Scores[:0]
+	for i := 0; i < len(moves); i++ {
+		count := pos.MakeMove(moves[i])
+		scores = append(scores, pos.Score())
+		pos.Undo(moves[i], count)
+	}
+	solver.Scores = scores
+
+	for i := 1; i < len(moves); i++ {
+		for j := i; (j > 0) && (scores[j] > scores[j-1]); j-- {
+			moves[j], moves[j-1] = moves[j-1], moves[j]
+			scores[j], scores[j-1] = scores[j-1], scores[j]
+		}
+	}
+}
+
+func SortHashes(hashes []uint64) {
+	for i := 1; i < len(hashes); i++ {
+		for j := i; (j > 0) && (hashes[j] < hashes[j-1]); j-- {
+			hashes[j], hashes[j-1] = hashes[j-1], hashes[j]
+		}
+	}
+}
+
+/*
+ * SolveFreeCell searches for sequence of moves which wins the game from its current position. Game itself is not modified.
+ * Search is done in three stages. First two skip pointless moves, so they find most wins quickly:
+ *	depth-first search, which wins most deals at once, but may wander for long on others, gets twentieth of the limit;
+ *	best-first search, which is slower but steadier, gets half of it.
+ * Last stage tries every move depth-first, so that it can prove that game is lost.
+ * NOTE(anton2920): positions are remembered by their hashes, so in extremely rare case of collision solver may miss a win.
+ */
+func SolveFreeCell(game *FreeCell, limit int) Solution {
+	return SolveFreeCellPosition(NewFreeCellPosition(game), limit, nil)
+}
+
+/* FreeCellStage is one search solver makes from the start, with its own limit of positions. */
+type FreeCellStage struct {
+	Search func(*FreeCellSolver) SolveStatus
+	Limit  int
+	Prune  bool
+}
+
+/* SolveFreeCellPosition solves start, giving up when stop is set. */
+func SolveFreeCellPosition(start FreeCellPosition, limit int, stop *atomic.Bool) Solution {
+	return SolveFreeCellStages(start, stop,
+		FreeCellStage{(*FreeCellSolver).Search, limit / 20, true},
+		FreeCellStage{(*FreeCellSolver).SearchBest, limit / 2, true},
+		FreeCellStage{(*FreeCellSolver).Search, limit, false},
+	)
+}
+
+/*
+ * SolveFreeCellBest solves start with best-first search alone. It wins fewer deals than SolveFreeCellPosition, but its solutions are much shorter than ones of depth-first search.
+ * NOTE(anton2920): search is not exhaustive, so solution is not guaranteed to be the shortest one.
+ */
+func SolveFreeCellBest(start FreeCellPosition, limit int, stop *atomic.Bool) Solution {
+	return SolveFreeCellStages(start, stop, FreeCellStage{(*FreeCellSolver).SearchBest, limit, true})
+}
+
+/* SolveFreeCellStages makes searches one after another, until one of them wins. */
+func SolveFreeCellStages(start FreeCellPosition, stop *atomic.Bool, stages ...FreeCellStage) Solution {
+	defer trace.End(trace.Begin(""))
+
+	solver := FreeCellSolver{Stop: stop, Visited: make(map[uint64]struct{}), Seed: maphash.MakeSeed()}
+
+	var status SolveStatus
+	for i := 0; (i < len(stages)) && (status != SolveWon) && (!Stopped(stop)); i++ {
+		stage := &stages[i]
+
+		solver.Position = start.Clone()
+		solver.Position.Prune = stage.Prune
+		solver.Limit = stage.Limit
+		clear(solver.Visited)
+		status = stage.Search(&solver)
+	}
+	solver.Tree = nil
+	solver.Queue = nil
+
+	if (status == SolveLost) && ((solver.Nodes >= solver.Limit) || (Stopped(stop))) {
+		status = SolveUnknown
+	}
+
+	solution := Solution{Status: status, Nodes: solver.Nodes}
+	if status == SolveWon {
+		solution.Moves = solver.Moves
+	}
+	return solution
+}
+
+/* MakeSafeMoves moves every card which is not needed on the table to foundations, just like autoplay does it. */
+func (solver *FreeCellSolver) MakeSafeMoves() {
+	pos := &solver.Position
+	for {
+		move, ok := pos.SafeMove()
+		if !ok {
+			break
+		}
+		solver.Counts = append(solver.Counts, pos.MakeMove(move))
+		solver.Moves = append(solver.Moves, move)
+	}
+}
+
+/* UndoMoves takes back moves until only n of them are left. */
+func (solver *FreeCellSolver) UndoMoves(n int) {
+	for i := len(solver.Moves) - 1; i >= n; i-- {
+		solver.Position.Undo(solver.Moves[i], solver.Counts[i])
+	}
+	solver.Moves = solver.Moves[:n]
+	solver.Counts = solver.Counts[:n]
+}
+
+/*
+ * SearchBest always continues from the position which looks closest to win. It is not exhaustive, since it skips pointless moves.
+ * Nodes counts every position search has reached, since all of them are kept in memory.
+ */
+func (solver *FreeCellSolver) SearchBest() SolveStatus {
+	pos := &solver.Position
+
+	solver.MakeSafeMoves()
+	if pos.Won() {
+		return SolveWon
+	}
+	solver.Visited[solver.Key()] = struct{}{}
+	solver.Tree = append(solver.Tree[:0], FreeCellNode{Position: pos.AppendPosition(nil), Parent: -1, Moves: append([]Move(nil), solver.Moves...)})
+	solver.Queue = append(solver.Queue[:0], FreeCellQueueItem{Score: pos.Score(), Node: 0})
+
+	var moves []Move
+	for (len(solver.Queue) > 0) && (solver.Nodes < solver.Limit) && (!Stopped(solver.Stop)) {
+		parent := solver.PopQueue().Node
+		pos.LoadPosition(solver.Tree[parent].Position)
+		solver.Moves = solver.Moves[:0]
+		solver.Counts = solver.Counts[:0]
+
+		moves = pos.Moves(moves[:0])
+		for i := 0; i < len(moves); i++ {
+			solver.Counts = append(solver.Counts, pos.MakeMove(moves[i]))
+			solver.Moves = append(solver.Moves, moves[i])
+			solver.MakeSafeMoves()
+
+			key := solver.Key()
+			if _, ok := solver.Visited[key]; !ok {
+				solver.Visited[key] = struct{}{}
+				solver.Nodes++
+
+				solver.Tree = append(solver.Tree, FreeCellNode{Position: pos.AppendPosition(nil), Parent: parent, Moves: append([]Move(nil), solver.Moves...)})
+				if pos.Won() {
+					solver.Moves = solver.Path(len(solver.Tree) - 1)
+					return SolveWon
+				}
+				solver.PushQueue(FreeCellQueueItem{Score: pos.Score(), Node: len(solver.Tree) - 1})
+			}
+			solver.UndoMoves(0)
+		}
+	}
+
+	solver.Moves = solver.Moves[:0]
+	solver.Counts = solver.Counts[:0]
+	return SolveLost
+}
+
+/* Path returns moves which lead from the root to the node. */
+func (solver *FreeCellSolver) Path(node int) []Move {
+	var n int
+	for i := node; i >= 0; i = solver.Tree[i].Parent {
+		n += len(solver.Tree[i].Moves)
+	}
+
+	path := make([]Move, n)
+	for i := node; i >= 0; i = solver.Tree[i].Parent {
+		n -= len(solver.Tree[i].Moves)
+		copy(path[n:], solver.Tree[i].Moves)
+	}
+	return path
+}
+
+/* PushQueue and PopQueue keep queue as a heap with the highest score on top. */
+func (solver *FreeCellSolver) PushQueue(item FreeCellQueueItem) {
+	queue := append(solver.Queue, item)
+	for i := len(queue) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if queue[parent].Score >= queue[i].Score {
+			break
+		}
+		queue[parent], queue[i] = queue[i], queue[parent]
+		i = parent
+	}
+	solver.Queue = queue
+}
+
+func (solver *FreeCellSolver) PopQueue() FreeCellQueueItem {
+	queue := solver.Queue
+	top := queue[0]
+
+	queue[0] = queue[len(queue)-1]
+	queue = queue[:len(queue)-1]
+	for i := 0; ; {
+		largest := i
+		for _, child := range [...]int{2*i + 1, 2*i + 2} {
+			if (child < len(queue)) && (queue[child].Score > queue[largest].Score) {
+				largest = child
+			}
+		}
+		if largest == i {
+			break
+		}
+		queue[largest], queue[i] = queue[i], queue[largest]
+		i = largest
+	}
+	solver.Queue = queue
+
+	return top
+}
+
+/* Search tries every move depth-first, so that when it fails within limit, game can not be won. */
+func (solver *FreeCellSolver) Search() SolveStatus {
+	safe := len(solver.Moves)
+	solver.MakeSafeMoves()
+
+	status := solver.SearchMoves()
+	if status != SolveWon {
+		solver.UndoMoves(safe)
+	}
+	return status
+}
+
+func (solver *FreeCellSolver) SearchMoves() SolveStatus {
+	pos := &solver.Position
+
+	if pos.Won() {
+		return SolveWon
+	}
+	if (solver.Nodes >= solver.Limit) || (Stopped(solver.Stop)) {
+		return SolveLost
+	}
+
+	key := solver.Key()
+	if _, ok := solver.Visited[key]; ok {
+		return SolveLost
+	}
+	solver.Visited[key] = struct{}{}
+	solver.Nodes++
+
+	moves := pos.Moves(nil)
+	solver.SortMoves(moves)
+	for i := 0; i < len(moves); i++ {
+		count := pos.MakeMove(moves[i])
+		solver.Moves = append(solver.Moves, moves[i])
+		solver.Counts = append(solver.Counts, count)
+		if solver.Search() == SolveWon {
+			return SolveWon
+		}
+		solver.Moves = solver.Moves[:len(solver.Moves)-1]
+		solver.Counts = solver.Counts[:len(solver.Counts)-1]
+		pos.Undo(moves[i], count)
+	}
+
+	return SolveLost
+}
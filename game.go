@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/gr"
+	"github.com/anton2920/gofa/slices"
+	"github.com/anton2920/gofa/util"
+)
+
+type Game interface {
+	Name() string
+
+	Deal(N int)
+	NewRandomGame()
+
+	Update()
+	Render()
+
+	Won() bool
+	Lost() bool
+
+	/* Dealing reports whether new deal is still being looked for. Nothing but leaving the game may be done meanwhile. */
+	Dealing() bool
+
+	Save(w io.Writer) error
+	Load(r io.Reader) error
+
+	Options() []Option
+}
+
+/* Solvable is a Game which computer can solve and then play the solution. */
+type Solvable interface {
+	Solve()
+
+	CanPlaySolution() bool
+	PlaySolution()
+}
+
+/* Analysable is a Game whose position may be solved in background while it is played. */
+type Analysable interface {
+	AppendPosition(buffer []byte) []byte
+	SolveJob() SolveJob
+}
+
+/* Finishable is a Game which sends remaining cards to foundations by itself once it is clearly won. */
+type Finishable interface {
+	CanFinish() bool
+	Finish()
+}
+
+/* Modal is a Game which shows dialogs of its own. Game buttons do not get mouse while such dialog is open, see DrawGameButtons. */
+type Modal interface {
+	DialogOpen() bool
+}
+
+/* Undoable is a Game which keeps History of moves player has made. */
+type Undoable interface {
+	CanUndo() bool
+	Undo() error
+
+	/* LastMove returns the move which Undo takes back, in notation of the game. */
+	LastMove() string
+}
+
+/* Raceable is a Game whose deal solver may race against the player, see Race. */
+type Raceable interface {
+	Game
+
+	/* Restart deals current deal again, so that player starts the race together with solver. */
+	Restart()
+
+	/* RaceJob solves copy of current position on other goroutine and records what solver's board looks like after each move. */
+	RaceJob() RaceJob
+
+	DealNumber() int
+	CardsHome() int
+}
+
+/* Option is a setting which is switched between Values by clicking on it. */
+type Option struct {
+	Name   string
+	Values []string
+	Value  *int
+
+	/* Key names option in settings file. Options without it are not saved. */
+	Key string
+}
+
+type GameConstructor func(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *gr.Pixmap) Game
+
+type GameEntry struct {
+	Name  string
+	Label string
+	New   GameConstructor
+
+	Game Game
+}
+
+/* SaveFile is kept in ConfigDir together with settings, see SavePath. */
+const SaveFile = "solitaire.sav"
+
+/* SavePath returns where game is saved. Without ConfigDir it is the working directory. */
+func SavePath() string {
+	return filepath.Join(ConfigDir, SaveFile)
+}
+
+/* Games contains every registered game in order of registration. Main menu is built from it. */
+var Games []GameEntry
+
+func RegisterGame(name string, constructor GameConstructor) {
+	Games = append(Games, GameEntry{Name: name, Label: "Play " + name, New: constructor})
+}
+
+func FindGame(name string) *GameEntry {
+	for i := 0; i < len(Games); i++ {
+		if Games[i].Name == name {
+			return &Games[i]
+		}
+	}
+	return nil
+}
+
+func SetGameTitle(window *gui.Window, name string, N int) {
+	if window == nil {
+		return
+	}
+
+	var n int
+	buffer := make([]byte, 128)
+	n += copy(buffer[n:], Title)
+	n += copy(buffer[n:], ": ")
+	n += copy(buffer[n:], name)
+	n += copy(buffer[n:], " Game #")
+	n += slices.PutInt(buffer[n:], N)
+	title := util.Slice2String(buffer[:n])
+	window.SetTitle(title)
+}
+
+/* SaveGame writes name of the game followed by its state to path, making its directory if needed. */
+func SaveGame(game Game, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create save file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, game.Name())
+	if err := game.Save(w); err != nil {
+		return fmt.Errorf("failed to save %s: %w", game.Name(), err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write save file: %w", err)
+	}
+	return nil
+}
+
+func LoadGame(path string) (Game, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open save file: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	name, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read game name: %w", err)
+	}
+	name = strings.TrimSpace(name)
+
+	/* NOTE(anton2920): Load reads save completely before it changes the game, so game stays as it was if save is broken. */
+	entry := FindGame(name)
+	if entry == nil {
+		return nil, fmt.Errorf("unknown game %q", name)
+	}
+	if err := entry.Game.Load(r); err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", name, err)
+	}
+	return entry.Game, nil
+}
+
+/* WriteCards writes a line which consists of key followed by cards. */
+func WriteCards(w io.Writer, key string, cards []Card) {
+	buffer := make([]byte, 0, len(key)+3*len(cards)+1)
+	buffer = append(buffer, key...)
+	for i := 0; i < len(cards); i++ {
+		buffer = append(buffer, ' ')
+		buffer = AppendCard(buffer, &cards[i])
+	}
+	buffer = append(buffer, '\n')
+	w.Write(buffer)
+}
+
+/* ReadSave reads lines of "key values..." format which is produced by WriteCards. */
+func ReadSave(r io.Reader, f func(key string, values []string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := f(fields[0], fields[1:]); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func ParseCards(values []string) ([]Card, error) {
+	cards := make([]Card, len(values))
+	for i := 0; i < len(values); i++ {
+		card, err := ParseCard(values[i])
+		if err != nil {
+			return nil, err
+		}
+		cards[i] = card
+	}
+	return cards, nil
+}
+
+func ParseInt(values []string) (int, error) {
+	if len(values) != 1 {
+		return 0, fmt.Errorf("expected one value, got %d", len(values))
+	}
+	return strconv.Atoi(values[0])
+}
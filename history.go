@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/anton2920/gofa/trace"
+)
+
+/* HistoryEntry is state of the game before a move, as Save writes it, together with the move in notation of the game. */
+type HistoryEntry struct {
+	Move  string
+	State []byte
+}
+
+/*
+ * History keeps state of the game before every move player has made, so that moves may be taken back.
+ * Cards which game sends to foundations by itself after the move are taken back together with it.
+ */
+type History struct {
+	Entries []HistoryEntry
+
+	/* State is what the game looked like when Begin was called. */
+	State bytes.Buffer
+}
+
+func (history *History) Clear() {
+	history.Entries = history.Entries[:0]
+}
+
+/* Begin remembers state of the game before move is made. */
+func (history *History) Begin(game Game) {
+	defer trace.End(trace.Begin(""))
+
+	history.State.Reset()
+	game.Save(&history.State)
+}
+
+/* End records move which has been made since Begin. */
+func (history *History) End(move string) {
+	history.Entries = append(history.Entries, HistoryEntry{Move: move, State: bytes.Clone(history.State.Bytes())})
+}
+
+/* LastMove returns the move which Undo takes back. */
+func (history *History) LastMove() string {
+	if len(history.Entries) == 0 {
+		return ""
+	}
+	return history.Entries[len(history.Entries)-1].Move
+}
+
+/* Undo loads state of the game before its last move. Load forgets history, so it is put back without that move. */
+func (history *History) Undo(game Game) error {
+	defer trace.End(trace.Begin(""))
+
+	entries := history.Entries
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := game.Load(bytes.NewReader(entries[len(entries)-1].State)); err != nil {
+		return err
+	}
+	history.Entries = entries[:len(entries)-1]
+	return nil
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	"image/draw"
-	"image/png"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 
@@ -15,27 +16,37 @@ import (
 	"github.com/anton2920/gofa/intel"
 	"github.com/anton2920/gofa/log"
 	"github.com/anton2920/gofa/trace"
+	"github.com/anton2920/gofa/util"
 )
 
-type GameType int
+const Title = "Classic solitaire collection"
 
 const (
-	GameNone GameType = iota
-	GameSolitaire
-	GameFreeCell
+	DefaultWindowWidth  = 632
+	DefaultWindowHeight = 452
 )
 
-const Title = "Classic solitaire collection"
-
 var (
 	BuildMode string
 	Debug     bool
 )
 
-var (
-	CurrentGame  GameType
-	FreeCellGame FreeCell
-)
+var CurrentGame Game
+
+/* CurrentRace is played against solver on the deal of current game. */
+var CurrentRace Race
+
+/* Analyser tells whether current game may still be won. */
+var Analyser *BackgroundSolver
+
+/* LookOptions are shared by all games, they are chosen in dialog opened from game buttons and kept in settings. */
+var LookOptions []Option
+
+/* LookDialogOpen is set while LookOptions are shown. Game does not get mouse until dialog is closed. */
+var LookDialogOpen bool
+
+/* CurrentSettings are saved when look of the game is changed. */
+var CurrentSettings Settings
 
 func DrawRectWithShadow(renderer gui.Renderer, x0, y0, x1, y1 int, pclr, sclr color.Color) {
 	renderer.RenderLine(x0, y0, x1-1, y0, pclr)
@@ -44,23 +55,263 @@ func DrawRectWithShadow(renderer gui.Renderer, x0, y0, x1, y1 int, pclr, sclr co
 	renderer.RenderLine(x1, y0+1, x1, y1, sclr)
 }
 
-func DrawBackButton(window *gui.Window, ui *gui.UI) {
+/* TODO(anton2929): store it with card? */
+func GetCardPixmap(card *Card) gr.Pixmap {
+	defer trace.End(trace.Begin(""))
+
+	sprites := CardSprites.Pixmap(CardScale)
+
+	i := int(card.Value - 1)
+	j := int(card.Suit-1) + int(util.Bool2Int(card.Selected)*4)
+
+	return sprites.Sub(i*CardWidth, j*CardHeight, (i+1)*CardWidth, (j+1)*CardHeight)
+}
+
+func DrawCard(renderer gui.Renderer, card *Card) {
+	defer trace.End(trace.Begin(""))
+
+	switch {
+	case card.FaceDown:
+		DrawCardBack(renderer, int(card.X), int(card.Y))
+	case card.Suit == Blank:
+	case DrawnCards():
+		DrawnCard(renderer, card, int(card.X), int(card.Y))
+	default:
+		renderer.RenderPixmap(GetCardPixmap(card), int(card.X), int(card.Y))
+		if IndexOption == 1 {
+			DrawLargeIndex(renderer, card, int(card.X), int(card.Y))
+		}
+	}
+}
+
+func DrawCursor(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *gr.Pixmap, cursor CursorType) {
 	defer trace.End(trace.Begin(""))
 
-	ui.Layout.CurrentY = window.Height - 50
+	switch cursor {
+	case CursorDefault:
+		window.ShowCursor()
+	case CursorUp:
+		window.HideCursor()
+		old := assets.Alpha
+		assets.Alpha = gr.Alpha8bit
+		up := &CurrentTheme.CursorUp
+		renderer.RenderPixmap(up.Sub(assets), ui.MouseX-up.HotX, ui.MouseY-up.HotY)
+		assets.Alpha = old
+	case CursorDown:
+		window.HideCursor()
+		old := assets.Alpha
+		assets.Alpha = gr.Alpha8bit
+		down := &CurrentTheme.CursorDown
+		renderer.RenderPixmap(down.Sub(assets), ui.MouseX-down.HotX, ui.MouseY-down.HotY)
+		assets.Alpha = old
+	}
+}
+
+const (
+	/* GameButtonHeight is the step between game buttons, see GameButtons. */
+	GameButtonHeight = 50
+
+	GameButtonPadding = 20
+)
+
+/*
+ * GameButtons stacks buttons from the bottom left corner of the window up. When window is too short for all of them, they go on in the next column.
+ * Columns are as wide as the widest label which may be shown in them.
+ */
+type GameButtons struct {
+	Window *gui.Window
+	UI     *gui.UI
+
+	Left  int
+	Width int
+	Rows  int
+	Slot  int
+}
+
+func NewGameButtons(window *gui.Window, ui *gui.UI, labels ...string) GameButtons {
+	buttons := GameButtons{Window: window, UI: ui, Left: ui.Layout.CurrentX}
+	for i := 0; i < len(labels); i++ {
+		buttons.Width = max(buttons.Width, ui.Font.TextWidth(labels[i]))
+	}
+	buttons.Width += 2 * GameButtonPadding
+	buttons.Rows = max((window.Height-MenuBarHeight)/GameButtonHeight, 1)
+	return buttons
+}
+
+/* Next moves layout to the place of next button up. */
+func (buttons *GameButtons) Next() {
+	buttons.UI.Layout.CurrentX = buttons.Left + (buttons.Slot/buttons.Rows)*buttons.Width
+	buttons.UI.Layout.CurrentY = buttons.Window.Height - GameButtonHeight*(buttons.Slot%buttons.Rows+1)
+	buttons.Slot++
+}
+
+/* DrawGameButtons draws, from the top down: options, look, undo, finish, race, solver, save and back. They are placed from the bottom up, see GameButtons. */
+func DrawGameButtons(window *gui.Window, ui *gui.UI) {
+	defer trace.End(trace.Begin(""))
+
+	dealing := CurrentGame.Dealing()
+	options := CurrentGame.Options()
+
+	labels := []string{"Solver pace: " + RacePaceNames[len(RacePaceNames)-1], "Play solution", "Auto-finish"}
+	for i := 0; i < len(options); i++ {
+		for j := 0; j < len(options[i].Values); j++ {
+			labels = append(labels, options[i].Name+": "+options[i].Values[j])
+		}
+	}
+	layout := ui.Layout
+	buttons := NewGameButtons(window, ui, labels...)
+
+	buttons.Next()
 	if ui.Button(gui.ID(&CurrentGame), "Back") {
 		window.SetTitle(Title)
-		CurrentGame = GameNone
+		CurrentGame = nil
+		Analyser.Reset()
+		CurrentRace.End()
+		ui.Layout = layout
+		return
 	}
+
+	if !dealing {
+		buttons.Next()
+		if ui.Button(gui.ID2(gui.ID(&CurrentGame)), "Save") {
+			if err := SaveGame(CurrentGame, SavePath()); err != nil {
+				log.Errorf("Failed to save game: %v", err)
+			}
+		}
+	}
+
+	if solvable, ok := CurrentGame.(Solvable); (ok) && (!dealing) && (!CurrentRace.Running()) {
+		buttons.Next()
+		if solvable.CanPlaySolution() {
+			if ui.Button(gui.ID(solvable), "Play solution") {
+				solvable.PlaySolution()
+			}
+		} else if ui.Button(gui.ID(solvable), "Solve") {
+			solvable.Solve()
+		}
+	}
+
+	if raceable, ok := CurrentGame.(Raceable); (ok) && (!dealing) {
+		buttons.Next()
+		if CurrentRace.Running() {
+			if ui.Button(gui.ID(&CurrentRace), "Stop race") {
+				CurrentRace.End()
+			}
+		} else if ui.Button(gui.ID(&CurrentRace), "Race solver") {
+			CurrentRace.Begin(raceable)
+		}
+
+		buttons.Next()
+		if ui.Button(gui.ID(&CurrentRace.PaceOption), "Solver pace: "+RacePaceNames[CurrentRace.PaceOption]) {
+			CurrentRace.PaceOption = (CurrentRace.PaceOption + 1) % len(RacePaceNames)
+		}
+	}
+
+	if finishable, ok := CurrentGame.(Finishable); (ok) && (!dealing) && (finishable.CanFinish()) {
+		buttons.Next()
+		if ui.Button(gui.ID(finishable), "Auto-finish") {
+			finishable.Finish()
+		}
+	}
+
+	if undoable, ok := CurrentGame.(Undoable); (ok) && (!dealing) {
+		label := "Undo"
+		if move := undoable.LastMove(); move != "" {
+			label += " " + move
+		}
+
+		buttons.Next()
+		if (ui.Button(gui.ID(undoable), label)) && (undoable.CanUndo()) {
+			if err := undoable.Undo(); err != nil {
+				log.Errorf("Failed to undo move: %v", err)
+			}
+		}
+	}
+
+	buttons.Next()
+	if ui.Button(gui.ID(&LookDialogOpen), "Look...") {
+		LookDialogOpen = true
+	}
+
+	for i := len(options) - 1; i >= 0; i-- {
+		option := &options[i]
+
+		buttons.Next()
+		if ui.Button(gui.ID(option.Value), option.Name+": "+option.Values[*option.Value]) {
+			*option.Value = (*option.Value + 1) % len(option.Values)
+		}
+	}
+
+	ui.Layout = layout
+}
+
+/* DrawLookDialog shows LookOptions over the game. Settings are saved once it is closed. */
+func DrawLookDialog(window *gui.Window, renderer gui.Renderer, ui *gui.UI) {
+	defer trace.End(trace.Begin(""))
+
+	if !LookDialogOpen {
+		return
+	}
+
+	const width = 320
+	const text = "Look"
+	height := 40*len(LookOptions) + 90
+	x := window.Width/2 - width/2
+	y := max(window.Height/2-height/2, 0)
+
+	renderer.RenderSolidRectWH(x, y, width, height, color.RGB(0xD4, 0xD0, 0xC8))
+	DrawRectWithShadow(renderer, x, y, x+width-1, y+height-1, color.White, color.Black)
+	renderer.RenderText(text, ui.Font, x+width/2-ui.Font.TextWidth(text)/2, y+15, color.Black)
+
+	layout := ui.Layout
+	ui.Layout.CurrentX = x + 10
+	for i := 0; i < len(LookOptions); i++ {
+		option := &LookOptions[i]
+
+		ui.Layout.CurrentY = y + 45 + 40*i
+		if ui.Button(gui.ID(option.Value), option.Name+": "+option.Values[*option.Value]) {
+			*option.Value = (*option.Value + 1) % len(option.Values)
+		}
+	}
+
+	ui.Layout.CurrentY = y + height - 45
+	if ui.Button(gui.ID2(gui.ID(&LookDialogOpen)), "Close") {
+		LookDialogOpen = false
+		if len(ConfigDir) > 0 {
+			if err := SaveSettings(filepath.Join(ConfigDir, SettingsFile), CurrentSettings, LookOptions); err != nil {
+				log.Errorf("Failed to save settings: %v", err)
+			}
+		}
+	}
+	ui.Layout = layout
 }
 
-func DrawSolitaire(window *gui.Window, renderer gui.Renderer, ui *gui.UI) {
-	const text = "Playing Solitaire..."
+func DrawLost(window *gui.Window, renderer gui.Renderer, ui *gui.UI) {
+	const text = "No more moves"
 	textWidth := ui.Font.TextWidth(text)
 	textHeight := ui.Font.TextHeight(text)
 	renderer.RenderText(text, ui.Font, window.Width/2-textWidth/2, window.Height/2-textHeight/2, color.White)
+}
+
+func DrawMainMenu(window *gui.Window, renderer gui.Renderer, ui *gui.UI) {
+	defer trace.End(trace.Begin(""))
 
-	DrawBackButton(window, ui)
+	renderer.Clear(color.Black)
+	for i := 0; i < len(Games); i++ {
+		entry := &Games[i]
+		if ui.Button(gui.ID(entry), entry.Label) {
+			CurrentGame = entry.Game
+			CurrentGame.NewRandomGame()
+		}
+	}
+	if ui.Button(gui.ID(&Games), "Load saved game") {
+		game, err := LoadGame(SavePath())
+		if err != nil {
+			log.Errorf("Failed to load game: %v", err)
+		} else {
+			CurrentGame = game
+		}
+	}
 }
 
 func Image2RGBA(src image.Image) *image.RGBA {
@@ -95,17 +346,36 @@ func main() {
 	}
 	log.Infof("Starting Solitaire in %q mode... (%s)", BuildMode, runtime.Version())
 
-	f, err := os.Open("assets/assets.png")
-	if err != nil {
-		log.Fatalf("Failed to load assets file: %v", err)
+	/* NOTE(anton2920): usage: solitaire [-assets dir] [survey ...]. */
+	assets := flag.String("assets", "", "`directory` with theme which replaces built-in one, overrides assets from settings")
+	flag.Parse()
+
+	if flag.Arg(0) == "survey" {
+		if err := Survey(flag.Args()[1:]); err != nil {
+			log.Fatalf("Failed to run survey: %v", err)
+		}
+		return
 	}
-	assetsImage, err := png.Decode(f)
-	if err != nil {
-		log.Fatalf("Failed to decode assets data: %v", err)
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		ConfigDir = filepath.Join(dir, "solitaire")
 	}
-	assets := gr.NewPixmapFromImage(Image2RGBA(assetsImage), gr.AlphaOpaque)
 
-	window, err := gui.NewWindow("Classic solitaire collection", 632, 452, gui.WindowResizable)
+	if len(ConfigDir) > 0 {
+		var err error
+		if CurrentSettings, err = LoadSettings(filepath.Join(ConfigDir, SettingsFile)); err != nil {
+			log.Errorf("Failed to load settings: %v", err)
+		}
+	}
+
+	/* NOTE(anton2920): assets from flag are used for this run only, so they are not saved with settings. */
+	defaultAssets := CurrentSettings.Assets
+	if len(*assets) > 0 {
+		defaultAssets = *assets
+	}
+	RegisterTheme(LoadDefaultTheme(defaultAssets))
+
+	window, err := gui.NewWindow("Classic solitaire collection", DefaultWindowWidth, DefaultWindowHeight, gui.WindowResizable)
 	if err != nil {
 		log.Fatalf("Failed to open new window: %v", err)
 	}
@@ -114,6 +384,28 @@ func main() {
 	renderer := gui.NewSoftwareRenderer(window)
 	ui := gui.NewUI(renderer)
 
+	if len(ConfigDir) > 0 {
+		LoadVariants(filepath.Join(ConfigDir, "variants"))
+		LoadThemes(filepath.Join(ConfigDir, "themes"))
+	}
+	LookOptions = []Option{
+		Option{Name: "Card size", Values: ScalingNames[:], Value: &ScalingOption, Key: "card-size"},
+		Option{Name: "Theme", Values: ThemeNames, Value: &ThemeOption, Key: "theme"},
+		Option{Name: "Cards", Values: CardStyleNames[:], Value: &CardStyleOption, Key: "cards"},
+		Option{Name: "Suit colours", Values: SuitColorNames[:], Value: &SuitColorOption, Key: "suit-colours"},
+		Option{Name: "Index", Values: IndexNames[:], Value: &IndexOption, Key: "index"},
+		Option{Name: "Back", Values: CardBackNames, Value: &CardBackOption, Key: "back"},
+		Option{Name: "Table", Values: TableNames, Value: &TableOption, Key: "table"},
+	}
+	CurrentSettings.ApplyLook(LookOptions)
+	UpdateTheme()
+
+	for i := 0; i < len(Games); i++ {
+		Games[i].Game = Games[i].New(window, renderer, ui, &CurrentTheme.Pixmap)
+	}
+
+	Analyser = NewBackgroundSolver()
+
 	events := make([]gui.Event, 64)
 	quit := false
 
@@ -149,24 +441,34 @@ func main() {
 
 		ui.Begin()
 
-		switch CurrentGame {
-		case GameNone:
-			renderer.Clear(color.Black)
-			if ui.Button(gui.ID2(gui.ID(&CurrentGame)), "Play Solitaire") {
-				window.SetTitle(Title + ": Solitaire")
-				CurrentGame = GameSolitaire
+		UpdateTheme()
+		CardSprites.Update(window)
+
+		if CurrentGame == nil {
+			DrawMainMenu(window, renderer, ui)
+		} else {
+			/* NOTE(anton2920): game is still updated under dialog, so that it follows changes of look, but mouse is moved away from it. */
+			mouseX, mouseY := ui.MouseX, ui.MouseY
+			if LookDialogOpen {
+				ui.MouseX, ui.MouseY = -1, -1
 			}
-			if ui.Button(gui.ID3(gui.ID(&CurrentGame)), "Play FreeCell") {
-				FreeCellGame = NewFreeCell(window, renderer, ui, &assets)
-				CurrentGame = GameFreeCell
-				// const N = 17330
-				FreeCellGame.NewRandomGame()
+
+			CurrentGame.Update()
+			Analyser.Update(CurrentGame)
+			CurrentRace.Update(CurrentGame)
+			CurrentGame.Render()
+			Analyser.Draw(window, renderer, ui)
+			CurrentRace.Draw(window, renderer, ui)
+			if CurrentGame.Lost() {
+				DrawLost(window, renderer, ui)
+			}
+			if modal, ok := CurrentGame.(Modal); (ok) && (modal.DialogOpen()) {
+				ui.MouseX, ui.MouseY = -1, -1
 			}
-		case GameSolitaire:
-			DrawSolitaire(window, renderer, ui)
-		case GameFreeCell:
-			FreeCellGame.UpdateAndRender()
-			DrawBackButton(window, ui)
+			DrawGameButtons(window, ui)
+
+			ui.MouseX, ui.MouseY = mouseX, mouseY
+			DrawLookDialog(window, renderer, ui)
 		}
 
 		ui.End()
@@ -0,0 +1,144 @@
+package main
+
+import "github.com/anton2920/gofa/trace"
+
+/* Move takes cards of pile Src starting from Index and puts them on pile Dst. Move from stock deals cards from it. */
+type Move struct {
+	Src, Dst int
+	Index    int
+}
+
+/* NOTE(anton2920): piles are numbered as follows: stock, waste, reserve, cells, foundations, tableau. */
+const (
+	PileIDStock = iota
+	PileIDWaste
+	PileIDReserve
+	PileIDCells
+)
+
+func (game *Patience) NumPiles() int {
+	return PileIDCells + len(game.Cells) + len(game.Foundations) + len(game.Tableau)
+}
+
+func (game *Patience) Pile(id int) *Pile {
+	switch id {
+	case PileIDStock:
+		return &game.Stock
+	case PileIDWaste:
+		return &game.Waste
+	case PileIDReserve:
+		return &game.Reserve
+	}
+
+	id -= PileIDCells
+	if id < len(game.Cells) {
+		return &game.Cells[id]
+	}
+	id -= len(game.Cells)
+	if id < len(game.Foundations) {
+		return &game.Foundations[id]
+	}
+	id -= len(game.Foundations)
+	return &game.Tableau[id]
+}
+
+func (game *Patience) PileID(pile *Pile) int {
+	for id := 0; id < game.NumPiles(); id++ {
+		if game.Pile(id) == pile {
+			return id
+		}
+	}
+	return -1
+}
+
+/* CanDealFromStock reports whether click on stock does anything. */
+func (game *Patience) CanDealFromStock() bool {
+	switch game.Rules.Stock {
+	case StockWaste:
+		return (len(game.Stock.Cards) > 0) || ((game.Redeals != 0) && (len(game.Waste.Cards) > 0))
+	case StockTableau:
+		return len(game.Stock.Cards) > 0
+	}
+	return false
+}
+
+/* Moves appends every legal move to moves. */
+func (game *Patience) Moves(moves []Move) []Move {
+	defer trace.End(trace.Begin(""))
+
+	n := game.NumPiles()
+	for src := PileIDWaste; src < n; src++ {
+		pile := game.Pile(src)
+		for idx := 0; idx < len(pile.Cards); idx++ {
+			if !game.CanGrab(pile, idx) {
+				continue
+			}
+			for dst := PileIDCells; dst < n; dst++ {
+				if game.CanMove(pile, idx, game.Pile(dst)) {
+					moves = append(moves, Move{Src: src, Dst: dst, Index: idx})
+				}
+			}
+		}
+	}
+	if game.CanDealFromStock() {
+		moves = append(moves, Move{Src: PileIDStock})
+	}
+
+	return moves
+}
+
+func (game *Patience) MakeMove(move Move) {
+	defer trace.End(trace.Begin(""))
+
+	if move.Src == PileIDStock {
+		game.DealFromStock()
+		return
+	}
+
+	src := game.Pile(move.Src)
+	dst := game.Pile(move.Dst)
+
+	game.ScoreMove(src, dst, len(src.Cards)-move.Index)
+	dst.Cards = append(dst.Cards, src.Cards[move.Index:]...)
+	src.Cards = src.Cards[:move.Index]
+	game.FlipTop(src)
+
+	game.LayoutPile(src)
+	game.LayoutPile(dst)
+
+	if game.Rules.FillFromReserve {
+		game.FillFromReserve()
+	}
+}
+
+/* Clone returns copy of the game which does not share cards with it. */
+func (game *Patience) Clone() Patience {
+	clone := *game
+
+	clone.Tableau = ClonePiles(game.Tableau)
+	clone.Foundations = ClonePiles(game.Foundations)
+	clone.Cells = ClonePiles(game.Cells)
+	clone.Stock.Cards = append([]Card(nil), game.Stock.Cards...)
+	clone.Waste.Cards = append([]Card(nil), game.Waste.Cards...)
+	clone.Reserve.Cards = append([]Card(nil), game.Reserve.Cards...)
+
+	clone.SelectedPile = nil
+	clone.MoveBuffer = nil
+	clone.OptionList = nil
+	clone.Search = nil
+	clone.History = History{}
+	clone.Solving = false
+	clone.SolveResult = nil
+	clone.SolveStop = nil
+
+	return clone
+}
+
+func ClonePiles(piles []Pile) []Pile {
+	clone := make([]Pile, len(piles))
+	for i := 0; i < len(piles); i++ {
+		clone[i] = piles[i]
+		clone[i].Cards = append([]Card(nil), piles[i].Cards...)
+	}
+	return clone
+}
@@ -0,0 +1,1531 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/color"
+	"github.com/anton2920/gofa/gui/gr"
+	"github.com/anton2920/gofa/trace"
+	"github.com/anton2920/gofa/util"
+)
+
+type PileType int
+
+const (
+	PileTableau PileType = iota
+	PileFoundation
+	PileStock
+	PileWaste
+	PileReserve
+	PileCell
+)
+
+type Pile struct {
+	Type  PileType
+	Cards []Card
+
+	X, Y int
+}
+
+type ScoringType int
+
+const (
+	ScoringNone ScoringType = iota
+
+	/* ScoringStandard gives points for useful moves and takes them for recycling waste and for time. */
+	ScoringStandard
+
+	/* ScoringVegas costs $52 per deal and pays $5 for every card in foundations. */
+	ScoringVegas
+)
+
+const (
+	ScoreWaste2Tableau      = 5
+	ScoreCard2Foundation    = 10
+	ScoreTurnOver           = 5
+	ScoreFoundation2Tableau = -15
+	ScoreRecycleDrawOne     = -100
+	ScoreRecycleDrawThree   = -20
+	ScoreTimePenalty        = -2
+	ScoreTimePenaltyPeriod  = 10
+	ScoreTimeBonus          = 700000
+	ScoreTimeBonusMinTime   = 30
+
+	VegasDeal = -52
+	VegasCard = 5
+)
+
+type DealsType int
+
+const (
+	DealsAny DealsType = iota
+
+	/* DealsWinnable are won by solver which may take back moves after seeing face-down cards. */
+	DealsWinnable
+
+	/* DealsGuaranteed are won by solver which never looks at face-down cards. */
+	DealsGuaranteed
+)
+
+const (
+	/* DealSolveLimit is smaller than DefaultSolveLimit, so that new game starts quickly. */
+	DealSolveLimit = 20000
+	DealTries      = 50
+
+	SolutionMoveDelay = 250 * time.Millisecond
+)
+
+/* Patience is a game that is played on piles of cards and is described by its Rules. */
+type Patience struct {
+	/* Window-related stuff. */
+	Window   *gui.Window
+	Renderer gui.Renderer
+	UI       *gui.UI
+	Assets   *gr.Pixmap
+
+	/* Game-related stuff. */
+	Rules  *Rules
+	State  GameState
+	Number int
+
+	Tableau     []Pile
+	Foundations []Pile
+	Stock       Pile
+	Waste       Pile
+	Reserve     Pile
+	Cells       []Pile
+
+	BaseValue ValueType
+	Draw      int
+	Redeals   int
+
+	/* Score is kept without time penalty and bonus, see CurrentScore. */
+	Score int
+	Bank  int
+
+	/* DealBank is Vegas bank right after current deal was paid for, see Restart. */
+	DealBank int
+	Start    time.Time
+	Seconds  int
+
+	/* DrawValues and RedealValues are what DrawOption and PassesOption choose from, see NewStockOptions. */
+	DrawValues   []int
+	RedealValues []int
+
+	DrawOption     int
+	PassesOption   int
+	ScoringOption  int
+	BankOption     int
+	DealsOption    int
+	AutoplayOption int
+	OptionList     []Option
+
+	SelectedPile  *Pile
+	SelectedIndex int
+
+	/* Returned is the card which has been moved back from foundation. Autoplay leaves it on the table until other cards are selected. */
+	Returned Card
+
+	/* History has moves player has made, see PlayMove. */
+	History History
+
+	/* Search looks for new deal, see NewRandomGame. */
+	Search *DealSearch
+
+	MoveBuffer []Move
+
+	/* Solution is valid for current position until player makes a move. */
+	Solution Solution
+	Solved   bool
+
+	/* Solving is set while Solve runs on other goroutine. SolveResult gets its solution and SolveStop cancels it. */
+	Solving     bool
+	SolveResult chan Solution
+	SolveStop   *atomic.Bool
+
+	Playing      bool
+	PlayedMoves  int
+	LastMoveTime time.Time
+
+	Cursor CursorType
+
+	/* Measurements. Width is the width of piles, which are centred in window of LayoutWidth and LayoutHeight with cards of LayoutScale. */
+	Width        int
+	Left         int
+	LayoutWidth  int
+	LayoutHeight int
+	LayoutScale  int
+	MenuHeight   int
+
+	TopRowTop    int
+	TableLeft    int
+	TableTop     int
+	TableSpace   int
+	StatusHeight int
+
+	StatusBuffer []byte
+}
+
+func (pile *Pile) Top() *Card {
+	if len(pile.Cards) == 0 {
+		return nil
+	}
+	return &pile.Cards[len(pile.Cards)-1]
+}
+
+func NewPatience(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *gr.Pixmap, rules *Rules) Patience {
+	var game Patience
+
+	game.Window = window
+	game.Renderer = renderer
+	game.UI = ui
+	game.Assets = assets
+
+	game.Rules = rules
+
+	game.MenuHeight = 20
+	game.StatusHeight = 20
+	game.TopRowTop = game.MenuHeight
+
+	game.Tableau = make([]Pile, rules.Columns)
+	for i := 0; i < len(game.Tableau); i++ {
+		pile := &game.Tableau[i]
+		pile.Type = PileTableau
+		pile.Cards = make([]Card, 0, rules.Decks*52)
+	}
+
+	game.Reserve.Type = PileReserve
+	game.Reserve.Cards = make([]Card, 0, rules.Reserve)
+
+	game.Foundations = make([]Pile, rules.Decks*4)
+	for i := 0; i < len(game.Foundations); i++ {
+		pile := &game.Foundations[i]
+		pile.Type = PileFoundation
+		pile.Cards = make([]Card, 0, 13)
+		pile.Y = game.TopRowTop
+	}
+
+	game.Stock.Type = PileStock
+	game.Stock.Cards = make([]Card, 0, rules.Decks*52)
+	game.Stock.Y = game.TopRowTop
+
+	game.Waste.Type = PileWaste
+	game.Waste.Cards = make([]Card, 0, rules.Decks*52)
+	game.Waste.Y = game.TopRowTop
+
+	game.Cells = make([]Pile, rules.Cells)
+	for i := 0; i < len(game.Cells); i++ {
+		pile := &game.Cells[i]
+		pile.Type = PileCell
+		pile.Cards = make([]Card, 0, 1)
+		pile.Y = game.TopRowTop
+	}
+
+	game.Resize(DefaultWindowWidth, DefaultWindowHeight)
+
+	if rules.StockOptions {
+		game.DrawValues, game.DrawOption = NewStockOptions(rules.Draw, 1, 3)
+		game.RedealValues, game.PassesOption = NewStockOptions(rules.Redeals, -1, 0, 2)
+	}
+
+	game.StatusBuffer = make([]byte, 0, 64)
+	game.MoveBuffer = make([]Move, 0, 64)
+
+	return game
+}
+
+func (game *Patience) Deal(N int) {
+	game.CancelSearch()
+	game.SelectedPile = nil
+	game.Returned = Card{}
+	game.History.Clear()
+	game.ForgetSolution()
+
+	deck := NewDeck(game.Rules.Decks)
+	r := rand.New(rand.NewSource(int64(N)))
+	r.Shuffle(len(deck), func(i, j int) {
+		deck[i], deck[j] = deck[j], deck[i]
+	})
+
+	game.Reserve.Cards = game.Reserve.Cards[:0]
+	for i := 0; i < game.Rules.Reserve; i++ {
+		card := deck[len(deck)-1]
+		card.FaceDown = (!game.Rules.Open) && (i < game.Rules.Reserve-1)
+		game.Reserve.Cards = append(game.Reserve.Cards, card)
+		deck = deck[:len(deck)-1]
+	}
+
+	for i := 0; i < len(game.Foundations); i++ {
+		game.Foundations[i].Cards = game.Foundations[i].Cards[:0]
+	}
+	for i := 0; i < len(game.Cells); i++ {
+		game.Cells[i].Cards = game.Cells[i].Cards[:0]
+	}
+	game.BaseValue = game.Rules.Base
+	if game.Rules.DealBase {
+		card := deck[len(deck)-1]
+		game.BaseValue = card.Value
+		game.Foundations[0].Cards = append(game.Foundations[0].Cards, card)
+		deck = deck[:len(deck)-1]
+	}
+
+	for i := 0; i < len(game.Tableau); i++ {
+		pile := &game.Tableau[i]
+		pile.Cards = pile.Cards[:0]
+
+		for j := 0; (i < len(game.Rules.DealDown)) && (j < game.Rules.DealDown[i]); j++ {
+			card := deck[len(deck)-1]
+			card.FaceDown = !game.Rules.Open
+			pile.Cards = append(pile.Cards, card)
+			deck = deck[:len(deck)-1]
+		}
+		for j := 0; (i < len(game.Rules.DealUp)) && (j < game.Rules.DealUp[i]); j++ {
+			pile.Cards = append(pile.Cards, deck[len(deck)-1])
+			deck = deck[:len(deck)-1]
+		}
+	}
+
+	game.Stock.Cards = game.Stock.Cards[:0]
+	for i := 0; i < len(deck); i++ {
+		card := deck[i]
+		card.FaceDown = !game.Rules.Open
+		game.Stock.Cards = append(game.Stock.Cards, card)
+	}
+	game.Waste.Cards = game.Waste.Cards[:0]
+
+	game.Draw = game.Rules.Draw
+	game.Redeals = game.Rules.Redeals
+	if game.Rules.StockOptions {
+		game.Draw = game.DrawValues[game.DrawOption]
+		game.Redeals = game.RedealValues[game.PassesOption]
+	}
+
+	switch ScoringType(game.ScoringOption) {
+	default:
+		game.Score = 0
+	case ScoringVegas:
+		if game.BankOption == 0 {
+			game.Bank = 0
+		}
+		game.Bank += VegasDeal
+		game.Score = game.Bank
+		game.DealBank = game.Bank
+	}
+	game.Start = time.Now()
+	game.Seconds = 0
+
+	game.Layout()
+
+	game.Number = N
+	SetGameTitle(game.Window, game.Rules.Name, N)
+
+	game.State = GameRunning
+}
+
+/* NewRandomGame looks for deal which solver chosen in options wins in background, see DealSearch. Table is empty until it is found. */
+func (game *Patience) NewRandomGame() {
+	game.CancelSearch()
+	if DealsType(game.DealsOption) == DealsAny {
+		game.Deal(RandomDeal())
+		return
+	}
+
+	/* NOTE(anton2920): copy is made here, so that search does not touch the game while it is played. */
+	clone := game.Clone()
+	clone.Window = nil
+	clone.ScoringOption = int(ScoringNone)
+	blind := DealsType(game.DealsOption) == DealsGuaranteed
+	game.Search = StartDealSearch(func(N int, stop *atomic.Bool) (bool, bool) {
+		clone.Deal(N)
+
+		solver := PatienceSolver{Limit: DealSolveLimit, Blind: blind, Stop: stop}
+		won := solver.Solve(&clone).Status == SolveWon
+		return won, won
+	})
+
+	game.RemoveSelection()
+	game.ForgetSolution()
+	game.History.Clear()
+	for id := PileIDStock; id < game.NumPiles(); id++ {
+		pile := game.Pile(id)
+		pile.Cards = pile.Cards[:0]
+	}
+	game.State = GameNothing
+}
+
+func (game *Patience) CancelSearch() {
+	if game.Search != nil {
+		game.Search.Cancel()
+		game.Search = nil
+	}
+}
+
+func (game *Patience) Dealing() bool {
+	return game.Search != nil
+}
+
+/* Resize places piles in the middle of window of width and height, spreading them over it. Table starts below top row of cards of current size. */
+func (game *Patience) Resize(width, height int) {
+	defer trace.End(trace.Begin(""))
+
+	rules := game.Rules
+
+	/* NOTE(anton2920): reserve takes place of leftmost column. */
+	var reserveColumns int
+	if rules.Reserve > 0 {
+		reserveColumns = 1
+	}
+
+	var stockSlots int
+	switch rules.Stock {
+	case StockWaste:
+		stockSlots = 2
+	case StockTableau:
+		stockSlots = 1
+	}
+	slots := max(rules.Columns+reserveColumns, stockSlots+rules.Cells+rules.Decks*4)
+
+	game.TableSpace = min(max((width-slots*CardWidth)/(slots+1), MinTableSpace), MaxTableSpace)
+	game.Width = slots*CardWidth + (slots+1)*game.TableSpace
+	game.Left = max((width-game.Width)/2, 0)
+	game.TableLeft = game.Left + game.TableSpace
+	game.TableTop = game.TopRowTop + CardHeight + 10
+	game.LayoutWidth = width
+	game.LayoutHeight = height
+	game.LayoutScale = CardScale
+
+	for i := 0; i < len(game.Tableau); i++ {
+		game.Tableau[i].X = game.TableLeft + (i+reserveColumns)*(game.TableSpace+CardWidth)
+		game.Tableau[i].Y = game.TableTop
+	}
+	game.Reserve.X = game.TableLeft
+	game.Reserve.Y = game.TableTop
+	for i := 0; i < len(game.Foundations); i++ {
+		game.Foundations[i].X = game.Left + game.Width - (len(game.Foundations)-i)*(game.TableSpace+CardWidth)
+	}
+
+	/* NOTE(anton2920): stock, waste and cells are put in top row from the left. */
+	var slot int
+
+	game.Stock.X = game.TableLeft + slot*(game.TableSpace+CardWidth)
+	if rules.Stock != StockNone {
+		slot++
+	}
+	game.Waste.X = game.TableLeft + slot*(game.TableSpace+CardWidth)
+	if rules.Stock == StockWaste {
+		slot++
+	}
+	for i := 0; i < len(game.Cells); i++ {
+		game.Cells[i].X = game.TableLeft + (slot+i)*(game.TableSpace+CardWidth)
+	}
+
+	game.Layout()
+}
+
+/* TableauPadding returns space between face-up cards of the pile. It is made smaller when the pile would run into status bar. */
+func (game *Patience) TableauPadding(pile *Pile) int {
+	var faceDown, faceUp int
+	for i := 0; i < len(pile.Cards)-1; i++ {
+		if pile.Cards[i].FaceDown {
+			faceDown++
+		} else {
+			faceUp++
+		}
+	}
+	if faceUp == 0 {
+		return CardYPadding
+	}
+
+	/* NOTE(anton2920): face-up cards are never put closer than face-down ones, so that each of them may still be clicked. */
+	space := game.LayoutHeight - game.StatusHeight - pile.Y - CardHeight - faceDown*CardYPaddingFaceDown
+	return min(max(space/faceUp, CardYPaddingFaceDown), CardYPadding)
+}
+
+/*
+ * LayoutPile places cards of the pile on the table.
+ * NOTE(anton2920): copies without window are made by solvers, which may run in background while window is resized and sizes of cards change, so they are not laid out.
+ */
+func (game *Patience) LayoutPile(pile *Pile) {
+	if game.Window == nil {
+		return
+	}
+
+	padding := CardYPadding
+	if pile.Type == PileTableau {
+		padding = game.TableauPadding(pile)
+	}
+
+	y := pile.Y
+	for i := 0; i < len(pile.Cards); i++ {
+		card := &pile.Cards[i]
+		card.X = int16(pile.X)
+		card.Y = int16(y)
+
+		switch pile.Type {
+		case PileTableau:
+			if card.FaceDown {
+				y += CardYPaddingFaceDown
+			} else {
+				y += padding
+			}
+		case PileWaste:
+			/* NOTE(anton2920): last drawn cards are fanned out. */
+			if i >= len(pile.Cards)-game.Draw {
+				card.X += int16((i - (len(pile.Cards) - game.Draw)) * CardXPaddingWaste)
+			}
+		}
+	}
+}
+
+func (game *Patience) Layout() {
+	defer trace.End(trace.Begin(""))
+
+	for i := 0; i < len(game.Tableau); i++ {
+		game.LayoutPile(&game.Tableau[i])
+	}
+	for i := 0; i < len(game.Foundations); i++ {
+		game.LayoutPile(&game.Foundations[i])
+	}
+	game.LayoutPile(&game.Stock)
+	game.LayoutPile(&game.Waste)
+	game.LayoutPile(&game.Reserve)
+	for i := 0; i < len(game.Cells); i++ {
+		game.LayoutPile(&game.Cells[i])
+	}
+}
+
+func (game *Patience) CardRect(card *Card) gr.Rect {
+	return gr.Rect{int(card.X), int(card.Y), int(card.X) + CardWidth - 1, int(card.Y) + CardHeight - 1}
+}
+
+func (game *Patience) PileRect(pile *Pile) gr.Rect {
+	rect := gr.Rect{pile.X, pile.Y, pile.X + CardWidth - 1, pile.Y + CardHeight - 1}
+	if top := pile.Top(); top != nil {
+		rect.X1 = game.CardRect(top).X1
+		rect.Y1 = game.CardRect(top).Y1
+	}
+	return rect
+}
+
+/* CardAt returns index of the topmost card of the pile which is under mouse or -1. */
+func (game *Patience) CardAt(pile *Pile, mouse gr.Rect) int {
+	for i := len(pile.Cards) - 1; i >= 0; i-- {
+		if game.CardRect(&pile.Cards[i]).Contains(mouse) {
+			return i
+		}
+	}
+	return -1
+}
+
+/* IsRun reports whether cards of the pile starting from idx are built on each other. */
+func (game *Patience) IsRun(pile *Pile, idx int) bool {
+	for i := idx; i < len(pile.Cards)-1; i++ {
+		if !game.Rules.CanBuild(&pile.Cards[i+1], &pile.Cards[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (game *Patience) CanGrab(pile *Pile, idx int) bool {
+	defer trace.End(trace.Begin(""))
+
+	if (idx < 0) || (idx >= len(pile.Cards)) || (pile.Cards[idx].FaceDown) {
+		return false
+	}
+
+	switch pile.Type {
+	default:
+		return false
+	case PileWaste, PileReserve, PileCell:
+		return idx == len(pile.Cards)-1
+	case PileFoundation:
+		return (game.Rules.FromFoundation) && (!game.Rules.FoundationRuns) && (idx == len(pile.Cards)-1)
+	case PileTableau:
+	}
+
+	switch game.Rules.Grab {
+	case GrabRun:
+		return game.IsRun(pile, idx)
+	}
+	return true
+}
+
+/* CanMove reports whether cards of src starting from idx may be put on dst. */
+func (game *Patience) CanMove(src *Pile, idx int, dst *Pile) bool {
+	defer trace.End(trace.Begin(""))
+
+	if (src == nil) || (src == dst) || (idx < 0) || (idx >= len(src.Cards)) {
+		return false
+	}
+	if (src.Type == PileFoundation) && (dst.Type != PileTableau) {
+		return false
+	}
+	card := &src.Cards[idx]
+	count := len(src.Cards) - idx
+
+	switch dst.Type {
+	case PileTableau:
+		if (game.Rules.Supermove) && (count > game.AllowedToMove(dst)) {
+			return false
+		}
+		if len(dst.Cards) == 0 {
+			return game.Rules.CanFillEmpty(card)
+		}
+		return game.Rules.CanBuild(card, dst.Top())
+	case PileFoundation:
+		if game.Rules.FoundationRuns {
+			return (len(dst.Cards) == 0) && (IsCompleteSuit(src.Cards[idx:]))
+		}
+		return (count == 1) && (CanMove2Foundation(card, dst.Top(), game.BaseValue, game.Rules.Wrap))
+	case PileCell:
+		return (count == 1) && (len(dst.Cards) == 0)
+	}
+	return false
+}
+
+func (game *Patience) CanDrop(dst *Pile) bool {
+	return game.CanMove(game.SelectedPile, game.SelectedIndex, dst)
+}
+
+/* AllowedToMove returns how many cards may be moved to dst at once if only one card is moved at a time. */
+func (game *Patience) AllowedToMove(dst *Pile) int {
+	defer trace.End(trace.Begin(""))
+
+	var cells, columns uint
+	for i := 0; i < len(game.Cells); i++ {
+		if len(game.Cells[i].Cards) == 0 {
+			cells++
+		}
+	}
+	for i := 0; i < len(game.Tableau); i++ {
+		pile := &game.Tableau[i]
+		if (pile != dst) && (len(pile.Cards) == 0) {
+			columns++
+		}
+	}
+	return int((cells + 1) * (1 << columns))
+}
+
+func (game *Patience) SetSelection(pile *Pile, idx int) {
+	if !game.CanGrab(pile, idx) {
+		return
+	}
+
+	game.Returned = Card{}
+	game.SelectedPile = pile
+	game.SelectedIndex = idx
+	for i := idx; i < len(pile.Cards); i++ {
+		pile.Cards[i].Selected = true
+	}
+}
+
+func (game *Patience) RemoveSelection() {
+	if game.SelectedPile != nil {
+		pile := game.SelectedPile
+		for i := game.SelectedIndex; i < len(pile.Cards); i++ {
+			pile.Cards[i].Selected = false
+		}
+		game.SelectedPile = nil
+	}
+}
+
+/* FlipTop turns over the face-down card which has been uncovered. */
+func (game *Patience) FlipTop(pile *Pile) {
+	if top := pile.Top(); (top != nil) && (top.FaceDown) {
+		top.FaceDown = false
+		if (pile.Type == PileTableau) && (ScoringType(game.ScoringOption) == ScoringStandard) {
+			game.AddScore(ScoreTurnOver)
+		}
+	}
+}
+
+func (game *Patience) AddScore(score int) {
+	game.Score += score
+	if ScoringType(game.ScoringOption) == ScoringVegas {
+		game.Bank += score
+	}
+}
+
+/* ScoreMove changes score for moving cards from src to dst. */
+func (game *Patience) ScoreMove(src, dst *Pile, count int) {
+	switch ScoringType(game.ScoringOption) {
+	case ScoringStandard:
+		if dst.Type == PileFoundation {
+			game.AddScore(ScoreCard2Foundation * count)
+		} else if (src.Type == PileWaste) && (dst.Type == PileTableau) {
+			game.AddScore(ScoreWaste2Tableau)
+		} else if (src.Type == PileFoundation) && (dst.Type == PileTableau) {
+			game.AddScore(ScoreFoundation2Tableau)
+		}
+	case ScoringVegas:
+		if dst.Type == PileFoundation {
+			game.AddScore(VegasCard * count)
+		} else if src.Type == PileFoundation {
+			game.AddScore(-VegasCard * count)
+		}
+	}
+}
+
+/* CurrentScore returns score with time penalty and bonus applied. */
+func (game *Patience) CurrentScore() int {
+	score := game.Score
+	if ScoringType(game.ScoringOption) == ScoringStandard {
+		score += ScoreTimePenalty * (game.Seconds / ScoreTimePenaltyPeriod)
+		if (game.State == GameEnd) && (game.Seconds >= ScoreTimeBonusMinTime) {
+			score += ScoreTimeBonus / game.Seconds
+		}
+		score = max(score, 0)
+	}
+	return score
+}
+
+/* DealFromStock moves cards from stock to waste or turns waste over when stock is empty. */
+func (game *Patience) DealFromStock() {
+	defer trace.End(trace.Begin(""))
+
+	if game.Rules.Stock == StockTableau {
+		for i := 0; (i < len(game.Tableau)) && (len(game.Stock.Cards) > 0); i++ {
+			pile := &game.Tableau[i]
+			card := game.Stock.Cards[len(game.Stock.Cards)-1]
+			card.FaceDown = false
+			pile.Cards = append(pile.Cards, card)
+			game.Stock.Cards = game.Stock.Cards[:len(game.Stock.Cards)-1]
+			game.LayoutPile(pile)
+		}
+	} else if len(game.Stock.Cards) == 0 {
+		if (game.Redeals == 0) || (len(game.Waste.Cards) == 0) {
+			return
+		}
+		if game.Redeals > 0 {
+			game.Redeals--
+		}
+		if ScoringType(game.ScoringOption) == ScoringStandard {
+			if game.Draw == 1 {
+				game.AddScore(ScoreRecycleDrawOne)
+			} else {
+				game.AddScore(ScoreRecycleDrawThree)
+			}
+		}
+
+		for len(game.Waste.Cards) > 0 {
+			card := game.Waste.Cards[len(game.Waste.Cards)-1]
+			card.FaceDown = !game.Rules.Open
+			game.Stock.Cards = append(game.Stock.Cards, card)
+			game.Waste.Cards = game.Waste.Cards[:len(game.Waste.Cards)-1]
+		}
+	} else {
+		for i := 0; (i < game.Draw) && (len(game.Stock.Cards) > 0); i++ {
+			card := game.Stock.Cards[len(game.Stock.Cards)-1]
+			card.FaceDown = false
+			game.Waste.Cards = append(game.Waste.Cards, card)
+			game.Stock.Cards = game.Stock.Cards[:len(game.Stock.Cards)-1]
+		}
+	}
+
+	game.LayoutPile(&game.Stock)
+	game.LayoutPile(&game.Waste)
+}
+
+func (game *Patience) FillFromReserve() {
+	defer trace.End(trace.Begin(""))
+
+	for i := 0; (i < len(game.Tableau)) && (len(game.Reserve.Cards) > 0); i++ {
+		pile := &game.Tableau[i]
+		if len(pile.Cards) == 0 {
+			pile.Cards = append(pile.Cards, *game.Reserve.Top())
+			game.Reserve.Cards = game.Reserve.Cards[:len(game.Reserve.Cards)-1]
+			game.FlipTop(&game.Reserve)
+			game.LayoutPile(pile)
+		}
+	}
+	game.LayoutPile(&game.Reserve)
+}
+
+func (game *Patience) MoveSelection(dst *Pile) {
+	defer trace.End(trace.Begin(""))
+
+	src := game.SelectedPile
+	game.RemoveSelection()
+	game.ForgetSolution()
+	game.PlayMove(Move{Src: game.PileID(src), Dst: game.PileID(dst), Index: game.SelectedIndex})
+	if (src.Type == PileFoundation) && (dst.Type != PileFoundation) {
+		game.Returned = *dst.Top()
+	}
+}
+
+/* PlayMove makes move of player or of solution which is played and records it in History. */
+func (game *Patience) PlayMove(move Move) {
+	defer trace.End(trace.Begin(""))
+
+	game.History.Begin(game)
+	game.MakeMove(move)
+	game.History.End(game.Notation(move))
+}
+
+/* AppendPileName appends name of pile in move notation: stock is 's', waste is 'w', reserve is 'r', cells are lettered from 'a', foundations are 'h' and tableau is numbered from 1. */
+func (game *Patience) AppendPileName(buffer []byte, id int) []byte {
+	switch game.Pile(id).Type {
+	case PileStock:
+		return append(buffer, 's')
+	case PileWaste:
+		return append(buffer, 'w')
+	case PileReserve:
+		return append(buffer, 'r')
+	case PileCell:
+		return append(buffer, byte('a'+id-PileIDCells))
+	case PileFoundation:
+		return append(buffer, 'h')
+	default:
+		return strconv.AppendInt(buffer, int64(id-PileIDCells-len(game.Cells)-len(game.Foundations)+1), 10)
+	}
+}
+
+/*
+ * Notation writes move source pile first, as FreeCell moves are written. Move from foundation back to tableau pile 3 is "h3", deal from stock is "s".
+ * NOTE(anton2920): with ten piles or more numbers may run together, so they are separated by '-'.
+ */
+func (game *Patience) Notation(move Move) string {
+	buffer := make([]byte, 0, 8)
+	buffer = game.AppendPileName(buffer, move.Src)
+	if move.Src == PileIDStock {
+		return string(buffer)
+	}
+	if (len(game.Tableau) > 9) && (game.Pile(move.Src).Type == PileTableau) && (game.Pile(move.Dst).Type == PileTableau) {
+		buffer = append(buffer, '-')
+	}
+	buffer = game.AppendPileName(buffer, move.Dst)
+	return string(buffer)
+}
+
+/* IsSafeHome checks card against foundations by the same rule FreeCell uses. With more than one deck or unusual foundations no single card is known to be safe. */
+func (game *Patience) IsSafeHome(card *Card) bool {
+	rules := game.Rules
+	if rules.FoundationRuns {
+		return true
+	}
+	if (rules.Decks != 1) || (game.BaseValue != Ace) || (rules.Wrap) {
+		return false
+	}
+
+	var foundations [Aces + 1]ValueType
+	for i := 0; i < len(game.Foundations); i++ {
+		if top := game.Foundations[i].Top(); top != nil {
+			foundations[top.Suit] = top.Value
+		}
+	}
+	return IsSafeHome(rules, &foundations, card)
+}
+
+/* AutoplayMove returns move to foundation which policy allows. Card which player has just taken from foundation is left alone. */
+func (game *Patience) AutoplayMove(moves []Move, policy AutoplayType) (Move, bool) {
+	defer trace.End(trace.Begin(""))
+
+	for i := 0; i < len(moves); i++ {
+		move := moves[i]
+		if (move.Src == PileIDStock) || (game.Pile(move.Dst).Type != PileFoundation) {
+			continue
+		}
+
+		card := &game.Pile(move.Src).Cards[move.Index]
+		if (card.Suit == game.Returned.Suit) && (card.Value == game.Returned.Value) {
+			continue
+		}
+		if (policy == AutoplaySafe) && (!game.IsSafeHome(card)) {
+			continue
+		}
+		return move, true
+	}
+	return Move{}, false
+}
+
+/* Autoplay sends cards to foundations according to policy chosen in options. Nothing is moved while cards are selected or solution is played. */
+func (game *Patience) Autoplay() {
+	defer trace.End(trace.Begin(""))
+
+	policy := AutoplayType(game.AutoplayOption)
+	if (policy == AutoplayOff) || (game.SelectedPile != nil) || (game.Playing) {
+		return
+	}
+
+	for {
+		game.MoveBuffer = game.Moves(game.MoveBuffer[:0])
+		move, ok := game.AutoplayMove(game.MoveBuffer, policy)
+		if !ok {
+			return
+		}
+		game.ForgetSolution()
+		game.MakeMove(move)
+	}
+}
+
+func (game *Patience) CanUndo() bool {
+	return (game.State == GameRunning) && (!game.Playing) && (len(game.History.Entries) > 0)
+}
+
+/* Undo takes back the last move. Time keeps running, so it is not loaded with the rest of the game. */
+func (game *Patience) Undo() error {
+	if !game.CanUndo() {
+		return nil
+	}
+
+	seconds := game.Seconds
+	if err := game.History.Undo(game); err != nil {
+		return err
+	}
+	game.Seconds = seconds
+	game.Start = time.Now().Add(-time.Duration(seconds) * time.Second)
+	return nil
+}
+
+func (game *Patience) LastMove() string {
+	return game.History.LastMove()
+}
+
+/* HandleSingleCardInput handles piles from which only top card may be taken. */
+func (game *Patience) HandleSingleCardInput(pile *Pile, mouse gr.Rect) {
+	over := game.PileRect(pile).Contains(mouse)
+	pressed := game.UI.ButtonLogicDown(gui.ID(pile), over)
+
+	if (pressed) && (game.SelectedPile == nil) {
+		game.SetSelection(pile, len(pile.Cards)-1)
+	} else if (pressed) && (game.SelectedPile == pile) {
+		game.RemoveSelection()
+	} else if (over) && (game.CanDrop(pile)) {
+		game.Cursor = CursorUp
+		if pressed {
+			game.MoveSelection(pile)
+		}
+	}
+}
+
+func (game *Patience) HandleCardsInput() {
+	defer trace.End(trace.Begin(""))
+
+	mouse := gr.Rect{game.UI.MouseX, game.UI.MouseY, game.UI.MouseX, game.UI.MouseY}
+	game.Cursor = CursorDefault
+
+	for i := 0; i < len(game.Foundations); i++ {
+		foundation := &game.Foundations[i]
+		over := game.PileRect(foundation).Contains(mouse)
+		pressed := game.UI.ButtonLogicDown(gui.ID(foundation), over)
+
+		if (pressed) && (game.SelectedPile == nil) {
+			game.SetSelection(foundation, len(foundation.Cards)-1)
+		} else if (pressed) && (game.SelectedPile == foundation) {
+			game.RemoveSelection()
+		} else if (over) && (game.CanDrop(foundation)) {
+			game.Cursor = CursorUp
+			if pressed {
+				game.MoveSelection(foundation)
+			}
+		}
+	}
+
+	if game.Rules.Stock != StockNone {
+		over := game.PileRect(&game.Stock).Contains(mouse)
+		pressed := game.UI.ButtonLogicDown(gui.ID(&game.Stock), over)
+		if (pressed) && (game.CanDealFromStock()) {
+			game.RemoveSelection()
+			game.ForgetSolution()
+			game.Returned = Card{}
+			game.PlayMove(Move{Src: PileIDStock})
+		}
+	}
+
+	if game.Rules.Stock == StockWaste {
+		game.HandleSingleCardInput(&game.Waste, mouse)
+	}
+	if game.Rules.Reserve > 0 {
+		game.HandleSingleCardInput(&game.Reserve, mouse)
+	}
+	for i := 0; i < len(game.Cells); i++ {
+		game.HandleSingleCardInput(&game.Cells[i], mouse)
+	}
+
+	for i := 0; i < len(game.Tableau); i++ {
+		pile := &game.Tableau[i]
+		over := game.PileRect(pile).Contains(mouse)
+		pressed := game.UI.ButtonLogicDown(gui.ID(pile), over)
+
+		if (pressed) && (game.SelectedPile == nil) {
+			game.SetSelection(pile, game.CardAt(pile, mouse))
+		} else if (pressed) && (game.SelectedPile == pile) {
+			game.RemoveSelection()
+		} else if (over) && (game.CanDrop(pile)) {
+			game.Cursor = CursorDown
+			if pressed {
+				game.MoveSelection(pile)
+			}
+		}
+	}
+}
+
+func (game *Patience) Name() string {
+	return game.Rules.Name
+}
+
+/*
+ * NewStockOptions returns values which option of draw or redeals chooses from: value of the variant and usual ones, in ascending order.
+ * Index of value of the variant is returned too, so that it is chosen by default.
+ */
+func NewStockOptions(value int, usual ...int) ([]int, int) {
+	values := usual
+	if slices.Index(usual, value) == -1 {
+		values = append(usual, value)
+		sort.Ints(values)
+	}
+	return values, slices.Index(values, value)
+}
+
+func CountName(n int) string {
+	switch n {
+	case 1:
+		return "one"
+	case 2:
+		return "two"
+	case 3:
+		return "three"
+	}
+	return strconv.Itoa(n)
+}
+
+/* PassesName gives number of passes through stock, which is one more than number of redeals. */
+func PassesName(redeals int) string {
+	if redeals == -1 {
+		return "unlimited"
+	}
+	return CountName(redeals + 1)
+}
+
+/* Scored reports whether variant is scored like Klondike: Vegas pays for single deck, standard scoring charges for recycling waste. */
+func (game *Patience) Scored() bool {
+	return (game.Rules.Stock == StockWaste) && (game.Rules.Decks == 1)
+}
+
+/* Options returns settings which are applied on next deal. */
+func (game *Patience) Options() []Option {
+	if game.OptionList == nil {
+		if game.Rules.StockOptions {
+			draws := make([]string, len(game.DrawValues))
+			for i := 0; i < len(draws); i++ {
+				draws[i] = CountName(game.DrawValues[i])
+			}
+			passes := make([]string, len(game.RedealValues))
+			for i := 0; i < len(passes); i++ {
+				passes[i] = PassesName(game.RedealValues[i])
+			}
+
+			game.OptionList = append(game.OptionList,
+				Option{Name: "Draw", Values: draws, Value: &game.DrawOption},
+				Option{Name: "Passes", Values: passes, Value: &game.PassesOption})
+		}
+		if game.Scored() {
+			game.OptionList = append(game.OptionList, Option{Name: "Scoring", Values: []string{"none", "standard", "Vegas"}, Value: &game.ScoringOption})
+		}
+		game.OptionList = append(game.OptionList,
+			Option{Name: "Deals", Values: []string{"any", "winnable", "guaranteed winnable"}, Value: &game.DealsOption},
+			Option{Name: "Autoplay", Values: AutoplayNames[:], Value: &game.AutoplayOption},
+			Option{Name: "Vegas bank", Values: []string{"off", "cumulative"}, Value: &game.BankOption})
+	}
+
+	/* NOTE(anton2920): bank is only kept with Vegas scoring, so its option is hidden otherwise. It is the last one, so others stay where they are. */
+	if ScoringType(game.ScoringOption) != ScoringVegas {
+		return game.OptionList[:len(game.OptionList)-1]
+	}
+	return game.OptionList
+}
+
+/* Solve starts search for solution from current position on other goroutine, see PollSolution. Solution is shown in status bar and may be played with PlaySolution. */
+func (game *Patience) Solve() {
+	game.RemoveSelection()
+	game.ForgetSolution()
+
+	job := game.SolveJob()
+	stop := new(atomic.Bool)
+	result := make(chan Solution, 1)
+	go func() {
+		result <- job(stop, DefaultSolveLimit)
+	}()
+
+	game.Solving = true
+	game.SolveResult = result
+	game.SolveStop = stop
+}
+
+/* PollSolution picks up solution once Solve has found it. */
+func (game *Patience) PollSolution() {
+	if !game.Solving {
+		return
+	}
+
+	select {
+	case game.Solution = <-game.SolveResult:
+		game.Solving = false
+		game.SolveResult = nil
+		game.SolveStop = nil
+		game.Solved = true
+		game.Playing = false
+	default:
+	}
+}
+
+func (game *Patience) CanPlaySolution() bool {
+	return (game.State == GameRunning) && (game.Solved) && (!game.Playing) && (game.Solution.Status == SolveWon)
+}
+
+func (game *Patience) PlaySolution() {
+	if game.CanPlaySolution() {
+		game.RemoveSelection()
+		game.Playing = true
+		game.PlayedMoves = 0
+		game.LastMoveTime = time.Now()
+	}
+}
+
+/* ForgetSolution cancels Solve if it still runs, since position it solves is gone. */
+func (game *Patience) ForgetSolution() {
+	if game.SolveStop != nil {
+		game.SolveStop.Store(true)
+	}
+	game.Solving = false
+	game.SolveResult = nil
+	game.SolveStop = nil
+
+	game.Solution = Solution{}
+	game.Solved = false
+	game.Playing = false
+}
+
+/* PlayNextMove makes next move of solution when its time comes. */
+func (game *Patience) PlayNextMove() {
+	if time.Since(game.LastMoveTime) < SolutionMoveDelay {
+		return
+	}
+	if game.PlayedMoves >= len(game.Solution.Moves) {
+		game.ForgetSolution()
+		return
+	}
+
+	game.PlayMove(game.Solution.Moves[game.PlayedMoves])
+	game.PlayedMoves++
+	game.LastMoveTime = time.Now()
+}
+
+func (game *Patience) Won() bool {
+	defer trace.End(trace.Begin(""))
+
+	return game.CardsHome() == game.Rules.Decks*52
+}
+
+func (game *Patience) CardsHome() int {
+	var cards int
+	for i := 0; i < len(game.Foundations); i++ {
+		cards += len(game.Foundations[i].Cards)
+	}
+	return cards
+}
+
+func (game *Patience) DealNumber() int {
+	return game.Number
+}
+
+/* Restart deals current deal again. Vegas bank is set back to what it was when the deal was paid for, so that it is not paid twice. */
+func (game *Patience) Restart() {
+	bank := game.DealBank
+	game.Deal(game.Number)
+	if ScoringType(game.ScoringOption) == ScoringVegas {
+		game.Bank = bank
+		game.Score = bank
+		game.DealBank = bank
+	}
+}
+
+func (game *Patience) RaceJob() RaceJob {
+	clone := game.Clone()
+	clone.Window = nil
+	return func(stop *atomic.Bool) RaceRecord {
+		var record RaceRecord
+
+		solver := PatienceSolver{Limit: DefaultSolveLimit, Stop: stop}
+		record.Solution = solver.Solve(&clone)
+		for i := 0; i <= len(record.Solution.Moves); i++ {
+			record.Boards = append(record.Boards, clone.RaceBoard())
+			if i < len(record.Solution.Moves) {
+				clone.MakeMove(record.Solution.Moves[i])
+			}
+		}
+		return record
+	}
+}
+
+/* RaceBoard puts stock, waste, reserve, cells and foundations into top row of the board, followed by tableau. Only top cards of the top row are shown. */
+func (game *Patience) RaceBoard() RaceBoard {
+	var board RaceBoard
+
+	for id := PileIDStock; id < game.NumPiles(); id++ {
+		pile := game.Pile(id)
+		switch pile.Type {
+		case PileStock:
+			if game.Rules.Stock == StockNone {
+				continue
+			}
+		case PileWaste:
+			if game.Rules.Stock != StockWaste {
+				continue
+			}
+		case PileReserve:
+			if game.Rules.Reserve == 0 {
+				continue
+			}
+		case PileTableau:
+			board.Columns = append(board.Columns, append([]Card(nil), pile.Cards...))
+			continue
+		}
+
+		var card Card
+		if top := pile.Top(); top != nil {
+			card = *top
+		}
+		board.Top = append(board.Top, card)
+	}
+	board.Home = game.CardsHome()
+
+	return board
+}
+
+func (game *Patience) DrawMenu() {
+	defer trace.End(trace.Begin(""))
+
+	game.Renderer.RenderSolidRectWH(0, 0, game.Window.Width, game.MenuHeight, color.RGB(0xD4, 0xD0, 0xC8))
+}
+
+func (game *Patience) DrawStatus() {
+	defer trace.End(trace.Begin(""))
+
+	y := game.Window.Height - game.StatusHeight
+	game.Renderer.RenderSolidRectWH(0, y, game.Window.Width, game.StatusHeight, color.RGB(0xD4, 0xD0, 0xC8))
+
+	buffer := game.StatusBuffer[:0]
+	if game.Solving {
+		buffer = append(buffer, "Solver: thinking...   "...)
+	} else if game.Solved {
+		buffer = append(buffer, "Solver: "...)
+		buffer = append(buffer, game.Solution.Status.String()...)
+		if game.Solution.Status == SolveWon {
+			buffer = append(buffer, " in "...)
+			buffer = strconv.AppendInt(buffer, int64(len(game.Solution.Moves)-game.PlayedMoves), 10)
+			buffer = append(buffer, " moves"...)
+		}
+		buffer = append(buffer, "   "...)
+	}
+	if ScoringType(game.ScoringOption) != ScoringNone {
+		buffer = append(buffer, "Score: "...)
+		if ScoringType(game.ScoringOption) == ScoringVegas {
+			buffer = append(buffer, '$')
+		}
+		buffer = strconv.AppendInt(buffer, int64(game.CurrentScore()), 10)
+		buffer = append(buffer, "   "...)
+	}
+	buffer = append(buffer, "Time: "...)
+	buffer = strconv.AppendInt(buffer, int64(game.Seconds), 10)
+	game.StatusBuffer = buffer
+
+	text := util.Slice2String(buffer)
+	game.Renderer.RenderText(text, game.UI.Font, game.Window.Width-game.UI.Font.TextWidth(text)-10, y+(game.StatusHeight-game.UI.Font.TextHeight(text))/2, color.Black)
+}
+
+func (game *Patience) DrawBackground() {
+	defer trace.End(trace.Begin(""))
+
+	table := CurrentTable()
+	DrawTable(game.Window, game.Renderer)
+
+	for i := 0; i < len(game.Foundations); i++ {
+		pile := &game.Foundations[i]
+		DrawRectWithShadow(game.Renderer, pile.X, pile.Y, pile.X+CardWidth-1, pile.Y+CardHeight-1, table.Dark, table.Light)
+	}
+	for i := 0; i < len(game.Tableau); i++ {
+		pile := &game.Tableau[i]
+		DrawRectWithShadow(game.Renderer, pile.X, pile.Y, pile.X+CardWidth-1, pile.Y+CardHeight-1, table.Dark, table.Light)
+	}
+	if game.Rules.Stock != StockNone {
+		DrawRectWithShadow(game.Renderer, game.Stock.X, game.Stock.Y, game.Stock.X+CardWidth-1, game.Stock.Y+CardHeight-1, table.Dark, table.Light)
+	}
+	if game.Rules.Stock == StockWaste {
+		DrawRectWithShadow(game.Renderer, game.Waste.X, game.Waste.Y, game.Waste.X+CardWidth-1, game.Waste.Y+CardHeight-1, table.Dark, table.Light)
+	}
+	if game.Rules.Reserve > 0 {
+		DrawRectWithShadow(game.Renderer, game.Reserve.X, game.Reserve.Y, game.Reserve.X+CardWidth-1, game.Reserve.Y+CardHeight-1, table.Dark, table.Light)
+	}
+	for i := 0; i < len(game.Cells); i++ {
+		pile := &game.Cells[i]
+		DrawRectWithShadow(game.Renderer, pile.X, pile.Y, pile.X+CardWidth-1, pile.Y+CardHeight-1, table.Dark, table.Light)
+	}
+}
+
+func (game *Patience) DrawCards() {
+	defer trace.End(trace.Begin(""))
+
+	for i := 0; i < len(game.Foundations); i++ {
+		if top := game.Foundations[i].Top(); top != nil {
+			DrawCard(game.Renderer, top)
+		}
+	}
+	if top := game.Stock.Top(); top != nil {
+		DrawCard(game.Renderer, top)
+	}
+	if top := game.Waste.Top(); top != nil {
+		DrawCard(game.Renderer, top)
+	}
+	if top := game.Reserve.Top(); top != nil {
+		DrawCard(game.Renderer, top)
+	}
+	for i := 0; i < len(game.Cells); i++ {
+		if top := game.Cells[i].Top(); top != nil {
+			DrawCard(game.Renderer, top)
+		}
+	}
+	for i := 0; i < len(game.Tableau); i++ {
+		pile := &game.Tableau[i]
+		for j := 0; j < len(pile.Cards); j++ {
+			DrawCard(game.Renderer, &pile.Cards[j])
+		}
+	}
+}
+
+/* Lost reports whether there are no moves left. */
+func (game *Patience) Lost() bool {
+	defer trace.End(trace.Begin(""))
+
+	if game.State != GameRunning {
+		return false
+	}
+	game.MoveBuffer = game.Moves(game.MoveBuffer[:0])
+	return len(game.MoveBuffer) == 0
+}
+
+func (game *Patience) Save(w io.Writer) error {
+	fmt.Fprintf(w, "deal %d\n", game.Number)
+	fmt.Fprintf(w, "base %d\n", game.BaseValue)
+	fmt.Fprintf(w, "draw %d\n", game.Draw)
+	fmt.Fprintf(w, "redeals %d\n", game.Redeals)
+	fmt.Fprintf(w, "scoring %d\n", game.ScoringOption)
+	fmt.Fprintf(w, "score %d\n", game.Score)
+	fmt.Fprintf(w, "bank %d\n", game.Bank)
+	fmt.Fprintf(w, "deal-bank %d\n", game.DealBank)
+	fmt.Fprintf(w, "seconds %d\n", game.Seconds)
+	fmt.Fprintf(w, "autoplay %s\n", AutoplayNames[game.AutoplayOption])
+
+	for i := 0; i < len(game.Tableau); i++ {
+		WriteCards(w, "tableau", game.Tableau[i].Cards)
+	}
+	for i := 0; i < len(game.Foundations); i++ {
+		WriteCards(w, "foundation", game.Foundations[i].Cards)
+	}
+	WriteCards(w, "stock", game.Stock.Cards)
+	WriteCards(w, "waste", game.Waste.Cards)
+	WriteCards(w, "reserve", game.Reserve.Cards)
+	for i := 0; i < len(game.Cells); i++ {
+		WriteCards(w, "cell", game.Cells[i].Cards)
+	}
+	if game.Returned.Suit != Blank {
+		WriteCards(w, "returned", []Card{game.Returned})
+	}
+
+	return nil
+}
+
+/* Load reads the whole save into a copy of the game first, so that game is left as it was if save is broken. */
+func (game *Patience) Load(r io.Reader) error {
+	var tableau, foundations, cells int
+
+	saved := game.Clone()
+	saved.Window = nil
+	saved.Returned = Card{}
+	for id := PileIDStock; id < saved.NumPiles(); id++ {
+		pile := saved.Pile(id)
+		pile.Cards = pile.Cards[:0]
+	}
+
+	err := ReadSave(r, func(key string, values []string) error {
+		var pile *Pile
+		var err error
+
+		switch key {
+		default:
+			return fmt.Errorf("unknown key %q", key)
+		case "deal":
+			saved.Number, err = ParseInt(values)
+			return err
+		case "base":
+			var base int
+			base, err = ParseInt(values)
+			saved.BaseValue = ValueType(base)
+			return err
+		case "draw":
+			saved.Draw, err = ParseInt(values)
+			return err
+		case "redeals":
+			saved.Redeals, err = ParseInt(values)
+			return err
+		case "scoring":
+			saved.ScoringOption, err = ParseInt(values)
+			if (saved.ScoringOption < 0) || (saved.ScoringOption > int(ScoringVegas)) {
+				return fmt.Errorf("invalid scoring %d", saved.ScoringOption)
+			}
+			/* NOTE(anton2920): older versions offered scoring in every variant, so it is dropped where it is no longer offered. */
+			if !game.Scored() {
+				saved.ScoringOption = int(ScoringNone)
+			}
+			return err
+		case "score":
+			saved.Score, err = ParseInt(values)
+			return err
+		case "bank":
+			saved.Bank, err = ParseInt(values)
+			return err
+		case "deal-bank":
+			saved.DealBank, err = ParseInt(values)
+			return err
+		case "seconds":
+			saved.Seconds, err = ParseInt(values)
+			return err
+		case "autoplay":
+			saved.AutoplayOption, err = ParseWord(values, AutoplayNames[:]...)
+			return err
+		case "returned":
+			cards, err := ParseCards(values)
+			if err != nil {
+				return err
+			}
+			if len(cards) != 1 {
+				return fmt.Errorf("expected one returned card, got %d", len(cards))
+			}
+			saved.Returned = cards[0]
+			return nil
+		case "tableau":
+			if tableau >= len(saved.Tableau) {
+				return fmt.Errorf("too many tableau columns")
+			}
+			pile = &saved.Tableau[tableau]
+			tableau++
+		case "foundation":
+			if foundations >= len(saved.Foundations) {
+				return fmt.Errorf("too many foundations")
+			}
+			pile = &saved.Foundations[foundations]
+			foundations++
+		case "stock":
+			pile = &saved.Stock
+		case "waste":
+			pile = &saved.Waste
+		case "reserve":
+			pile = &saved.Reserve
+		case "cell":
+			if cells >= len(saved.Cells) {
+				return fmt.Errorf("too many cells")
+			}
+			pile = &saved.Cells[cells]
+			cells++
+		}
+
+		cards, err := ParseCards(values)
+		if err != nil {
+			return err
+		}
+		pile.Cards = append(pile.Cards[:0], cards...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if (tableau != len(saved.Tableau)) || (foundations != len(saved.Foundations)) || (cells != len(saved.Cells)) {
+		return fmt.Errorf("expected %d columns, %d foundations and %d cells, got %d, %d and %d", len(saved.Tableau), len(saved.Foundations), len(saved.Cells), tableau, foundations, cells)
+	}
+
+	game.CancelSearch()
+	game.RemoveSelection()
+	game.ForgetSolution()
+	game.History.Clear()
+	for id := PileIDStock; id < game.NumPiles(); id++ {
+		pile := game.Pile(id)
+		pile.Cards = append(pile.Cards[:0], saved.Pile(id).Cards...)
+	}
+	game.Number = saved.Number
+	game.BaseValue = saved.BaseValue
+	game.Draw = saved.Draw
+	game.Redeals = saved.Redeals
+	game.ScoringOption = saved.ScoringOption
+	game.Score = saved.Score
+	game.Bank = saved.Bank
+	game.DealBank = saved.DealBank
+	game.Seconds = saved.Seconds
+	game.AutoplayOption = saved.AutoplayOption
+	game.Returned = saved.Returned
+
+	game.Layout()
+
+	SetGameTitle(game.Window, game.Rules.Name, game.Number)
+	game.Start = time.Now().Add(-time.Duration(game.Seconds) * time.Second)
+	game.State = GameRunning
+	if game.Won() {
+		game.State = GameEnd
+	}
+
+	return nil
+}
+
+func (game *Patience) Update() {
+	defer trace.End(trace.Begin(""))
+
+	if (game.Window.Width != game.LayoutWidth) || (game.Window.Height != game.LayoutHeight) || (CardScale != game.LayoutScale) {
+		game.Resize(game.Window.Width, game.Window.Height)
+	}
+
+	if game.Search != nil {
+		if N, ok := game.Search.Poll(); ok {
+			game.Search = nil
+			game.Deal(N)
+		}
+	}
+
+	if game.State == GameRunning {
+		game.Seconds = int(time.Since(game.Start) / time.Second)
+		game.PollSolution()
+		if game.Playing {
+			game.PlayNextMove()
+		} else {
+			game.HandleCardsInput()
+			game.Autoplay()
+		}
+
+		if game.Won() {
+			game.State = GameEnd
+			game.Cursor = CursorDefault
+			game.UI.ClearActive()
+		}
+	}
+}
+
+func (game *Patience) Render() {
+	defer trace.End(trace.Begin(""))
+
+	game.DrawBackground()
+	game.DrawCards()
+
+	DrawCursor(game.Window, game.Renderer, game.UI, game.Assets, game.Cursor)
+	game.DrawMenu()
+	game.DrawStatus()
+	if game.Search != nil {
+		DrawDealSearch(game.Window, game.Renderer, game.UI)
+	}
+}
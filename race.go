@@ -0,0 +1,260 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/color"
+	"github.com/anton2920/gofa/trace"
+	"github.com/anton2920/gofa/util"
+)
+
+type RaceResult int
+
+const (
+	RaceRunning RaceResult = iota
+	RacePlayerFirst
+	RaceSolverFirst
+)
+
+var RacePaces = [...]time.Duration{4 * time.Second, 2 * time.Second, time.Second, 500 * time.Millisecond}
+
+var RacePaceNames = []string{"slow", "medium", "fast", "very fast"}
+
+/* RaceBoard is what solver's side of the race looks like: top row of piles, of which only top cards are kept, and columns. Blank card is an empty place. */
+type RaceBoard struct {
+	Top     []Card
+	Columns [][]Card
+
+	/* Home is the number of cards in foundations. */
+	Home int
+}
+
+/* RaceRecord is solution of race deal together with solver's board before first move and after each move. */
+type RaceRecord struct {
+	Solution Solution
+	Boards   []RaceBoard
+}
+
+/* RaceJob solves copy of position taken when job was made, so it may run on other goroutine. It gives up when stop is set. */
+type RaceJob func(stop *atomic.Bool) RaceRecord
+
+/*
+ * Race is played against solver which makes one move of its solution every pace.
+ * Solver does not play on the board, its progress is computed from the number of moves it has made.
+ * Deal is solved on other goroutine, so that window keeps responding. Pace is counted from the start, so solver catches up once solution is found.
+ */
+type Race struct {
+	Game Raceable
+	Deal int
+
+	Record  RaceRecord
+	Solving bool
+	Results chan RaceRecord
+	Stop    *atomic.Bool
+
+	Start      time.Time
+	PaceOption int
+	Moves      int
+	Result     RaceResult
+
+	Buffer []byte
+}
+
+/* Begin deals current deal of the game again and starts the race on it. */
+func (race *Race) Begin(game Raceable) {
+	defer trace.End(trace.Begin(""))
+
+	race.End()
+
+	game.Restart()
+	job := game.RaceJob()
+
+	stop := new(atomic.Bool)
+	results := make(chan RaceRecord, 1)
+	go func() {
+		results <- job(stop)
+	}()
+
+	race.Game = game
+	race.Deal = game.DealNumber()
+	race.Record = RaceRecord{}
+	race.Solving = true
+	race.Results = results
+	race.Stop = stop
+	race.Start = time.Now()
+	race.Moves = 0
+	race.Result = RaceRunning
+}
+
+func (race *Race) End() {
+	if race.Stop != nil {
+		race.Stop.Store(true)
+		race.Stop = nil
+	}
+	race.Game = nil
+	race.Solving = false
+}
+
+func (race *Race) Running() bool {
+	return (race.Game != nil) && (race.Result == RaceRunning)
+}
+
+/* Update moves solver on and finds out who finished first. Race ends when player leaves the game or starts another deal. */
+func (race *Race) Update(game Game) {
+	defer trace.End(trace.Begin(""))
+
+	if race.Game == nil {
+		return
+	}
+	if (game != race.Game) || (race.Game.DealNumber() != race.Deal) {
+		race.End()
+		return
+	}
+	if race.Result != RaceRunning {
+		return
+	}
+
+	if race.Solving {
+		select {
+		case race.Record = <-race.Results:
+			race.Solving = false
+			race.Stop = nil
+		default:
+		}
+	}
+
+	solution := &race.Record.Solution
+	if (!race.Solving) && (solution.Status == SolveWon) {
+		race.Moves = min(int(time.Since(race.Start)/RacePaces[race.PaceOption]), len(solution.Moves))
+	}
+	if game.Won() {
+		race.Result = RacePlayerFirst
+		if race.Stop != nil {
+			race.Stop.Store(true)
+			race.Stop = nil
+		}
+	} else if (!race.Solving) && (solution.Status == SolveWon) && (race.Moves == len(solution.Moves)) {
+		race.Result = RaceSolverFirst
+	}
+}
+
+/* Draw shows progress of both sides in menu bar and the winner in the middle of the window. */
+func (race *Race) Draw(window *gui.Window, renderer gui.Renderer, ui *gui.UI) {
+	defer trace.End(trace.Begin(""))
+
+	if race.Game == nil {
+		return
+	}
+
+	buffer := race.Buffer[:0]
+	buffer = append(buffer, "You: "...)
+	buffer = strconv.AppendInt(buffer, int64(race.Game.CardsHome()), 10)
+	buffer = append(buffer, " home   Solver: "...)
+	if race.Solving {
+		buffer = append(buffer, "thinking..."...)
+	} else if race.Record.Solution.Status == SolveWon {
+		buffer = strconv.AppendInt(buffer, int64(race.Record.Boards[race.Moves].Home), 10)
+		buffer = append(buffer, " home, "...)
+		buffer = strconv.AppendInt(buffer, int64(race.Moves), 10)
+		buffer = append(buffer, '/')
+		buffer = strconv.AppendInt(buffer, int64(len(race.Record.Solution.Moves)), 10)
+		buffer = append(buffer, " moves"...)
+	} else {
+		buffer = append(buffer, "gave up"...)
+	}
+	race.Buffer = buffer
+
+	text := util.Slice2String(buffer)
+	renderer.RenderText(text, ui.Font, 10, (MenuBarHeight-ui.Font.TextHeight(text))/2, color.Black)
+
+	if (!race.Solving) && (race.Record.Solution.Status == SolveWon) {
+		DrawRaceBoard(window, renderer, ui, &race.Record.Boards[race.Moves])
+	}
+
+	var result string
+	switch race.Result {
+	case RacePlayerFirst:
+		result = "You finished first!"
+	case RaceSolverFirst:
+		result = "Solver finished first"
+	default:
+		return
+	}
+	textWidth := ui.Font.TextWidth(result)
+	textHeight := ui.Font.TextHeight(result)
+	renderer.RenderSolidRectWH(window.Width/2-textWidth/2-10, window.Height/2-textHeight/2-10, textWidth+20, textHeight+20, color.RGB(0xD4, 0xD0, 0xC8))
+	renderer.RenderText(result, ui.Font, window.Width/2-textWidth/2, window.Height/2-textHeight/2, color.Black)
+}
+
+const (
+	RaceCardWidth  = 10
+	RaceCardHeight = 14
+
+	/* RaceCardStep is how much of every card in a column is seen under the next one. */
+	RaceCardStep = 4
+
+	RaceCardSpacing = 3
+	RacePanelMargin = 8
+)
+
+/* DrawRaceCard draws small card of solver's board. Only colour of suit is shown, there is no room for value. */
+func DrawRaceCard(renderer gui.Renderer, card *Card, x, y int) {
+	switch {
+	case card.Value == None:
+		table := CurrentTable()
+		DrawRectWithShadow(renderer, x, y, x+RaceCardWidth-1, y+RaceCardHeight-1, table.Dark, table.Light)
+	case card.FaceDown:
+		renderer.RenderSolidRectWH(x, y, RaceCardWidth, RaceCardHeight, color.Black)
+		renderer.RenderSolidRectWH(x+1, y+1, RaceCardWidth-2, RaceCardHeight-2, color.RGB(0, 0, 128))
+	default:
+		renderer.RenderSolidRectWH(x, y, RaceCardWidth, RaceCardHeight, color.Black)
+		renderer.RenderSolidRectWH(x+1, y+1, RaceCardWidth-2, RaceCardHeight-2, color.White)
+		renderer.RenderSolidRectWH(x+3, y+3, RaceCardWidth-6, RaceCardWidth-6, Palette[card.Suit].Color())
+	}
+}
+
+/* DrawRaceBoard shows solver's board in a panel at the bottom right corner of the window, so that player can follow what solver is doing. */
+func DrawRaceBoard(window *gui.Window, renderer gui.Renderer, ui *gui.UI, board *RaceBoard) {
+	defer trace.End(trace.Begin(""))
+
+	const caption = "Solver"
+	const step = RaceCardWidth + RaceCardSpacing
+
+	var rows int
+	for i := 0; i < len(board.Columns); i++ {
+		rows = max(rows, len(board.Columns[i]))
+	}
+	tableWidth := max(len(board.Top), len(board.Columns))*step + RaceCardSpacing
+	tableHeight := RaceCardSpacing + RaceCardHeight + RaceCardSpacing + max(rows-1, 0)*RaceCardStep + RaceCardHeight + RaceCardSpacing
+	captionHeight := ui.Font.TextHeight(caption) + 4
+
+	width := max(tableWidth, ui.Font.TextWidth(caption)) + 2*4
+	height := captionHeight + tableHeight + 4
+	x0 := window.Width - width - RacePanelMargin
+	y0 := window.Height - height - RacePanelMargin
+
+	renderer.RenderSolidRectWH(x0, y0, width, height, color.RGB(0xD4, 0xD0, 0xC8))
+	DrawRectWithShadow(renderer, x0, y0, x0+width-1, y0+height-1, color.White, color.Black)
+	renderer.RenderText(caption, ui.Font, x0+4, y0+2, color.Black)
+
+	x, y := x0+4, y0+captionHeight
+	renderer.RenderSolidRectWH(x, y, tableWidth, tableHeight, CurrentTable().Background.Color())
+
+	for i := 0; i < len(board.Top); i++ {
+		DrawRaceCard(renderer, &board.Top[i], x+RaceCardSpacing+i*step, y+RaceCardSpacing)
+	}
+	y += RaceCardSpacing + RaceCardHeight + RaceCardSpacing
+	for i := 0; i < len(board.Columns); i++ {
+		column := board.Columns[i]
+		if len(column) == 0 {
+			DrawRaceCard(renderer, &Card{}, x+RaceCardSpacing+i*step, y)
+			continue
+		}
+		for j := 0; j < len(column); j++ {
+			DrawRaceCard(renderer, &column[j], x+RaceCardSpacing+i*step, y+j*RaceCardStep)
+		}
+	}
+}
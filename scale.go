@@ -0,0 +1,277 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/gr"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* Cards are scaled in steps of 1/ScaleUnit. CardScale of ScaleUnit draws cards as they are in atlas. */
+const ScaleUnit = 8
+
+var CardScale = ScaleUnit
+
+type ScalingType int
+
+const (
+	ScalingOff ScalingType = iota
+	ScalingInteger
+	ScalingSmooth
+)
+
+var ScalingNames = [...]string{"fixed", "whole steps", "smooth"}
+
+/* ScalingOption is chosen in Look dialog together with other LookOptions, since card size is shared by all games. */
+var ScalingOption = int(ScalingSmooth)
+
+/* Cards are laid out in AtlasColumns values by four suits. Selected cards follow normal ones and back is put below them, see NewCardAtlas. */
+const (
+	AtlasColumns = 13
+	AtlasRows    = 9
+	AtlasBackRow = 8
+)
+
+/* CardAtlasCacheSize is the number of scaled atlases which are kept, so that resizing window back and forth does not scale them again. */
+const CardAtlasCacheSize = 4
+
+type ScaledAtlas struct {
+	Scale  int
+	Pixmap gr.Pixmap
+}
+
+/* CardAtlas keeps card images from theme and their copies scaled for the current window. Recently used copy comes first. */
+type CardAtlas struct {
+	Source *image.RGBA
+	Cache  []ScaledAtlas
+}
+
+/* CardSprites holds cards which are drawn by DrawCard. */
+var CardSprites CardAtlas
+
+func ScaleSize(size int, scale int) int {
+	return size * scale / ScaleUnit
+}
+
+/* SetCardScale changes size of cards and spacing between them. Games notice the change and lay themselves out again. */
+func SetCardScale(scale int) {
+	CardScale = scale
+
+	CardWidth = ScaleSize(BaseCardWidth, scale)
+	CardHeight = ScaleSize(BaseCardHeight, scale)
+	CardYPadding = ScaleSize(BaseCardYPadding, scale)
+	CardYPaddingFaceDown = ScaleSize(BaseCardYPaddingFaceDown, scale)
+	CardXPaddingWaste = ScaleSize(BaseCardXPaddingWaste, scale)
+
+	MaxTableSpace = CardWidth / 2
+}
+
+/* WindowCardScale returns scale at which default layout fills window of width and height. Cards are never made smaller. */
+func WindowCardScale(width, height int, scaling ScalingType) int {
+	scale := min(width*ScaleUnit/DefaultWindowWidth, height*ScaleUnit/DefaultWindowHeight)
+
+	switch scaling {
+	case ScalingOff:
+		scale = ScaleUnit
+	case ScalingInteger:
+		scale -= scale % ScaleUnit
+	}
+	return max(scale, ScaleUnit)
+}
+
+/*
+ * NewCardAtlas gathers cards of theme into grid of cells of the same size. Selected cards are made by inverting normal ones when theme has none.
+ * With fourColor suits are recoloured as FourColorPalette asks. Row of backs has back of theme followed by CardBacks.
+ */
+func NewCardAtlas(theme *Theme, fourColor bool) CardAtlas {
+	defer trace.End(trace.Begin(""))
+
+	var atlas CardAtlas
+
+	/* NOTE(anton2920): themes without cards still need backs, which are made of default size. */
+	width, height := theme.Cards.Width, theme.Cards.Height
+	if width == 0 {
+		width, height = BaseCardWidth, BaseCardHeight
+	}
+	atlas.Source = image.NewRGBA(image.Rect(0, 0, AtlasColumns*width, AtlasRows*height))
+
+	for j := 0; (j < 4) && (theme.Cards.Width > 0); j++ {
+		for i := 0; i < AtlasColumns; i++ {
+			suit := SuitType(j + 1)
+
+			cell := image.Rect(i*width, j*height, (i+1)*width, (j+1)*height)
+			draw.Draw(atlas.Source, cell, theme.Image, image.Pt(theme.Cards.X+i*width, theme.Cards.Y+j*height), draw.Src)
+			if fourColor {
+				RecolorSuit(atlas.Source.SubImage(cell).(*image.RGBA), suit)
+			}
+
+			selected := cell.Add(image.Pt(0, 4*height))
+			if theme.Selected.Width > 0 {
+				draw.Draw(atlas.Source, selected, theme.Image, image.Pt(theme.Selected.X+i*width, theme.Selected.Y+j*height), draw.Src)
+				if fourColor {
+					/* NOTE(anton2920): selected cards are inverted, so they are recoloured as normal ones would be. */
+					img := atlas.Source.SubImage(selected).(*image.RGBA)
+					InvertColors(img)
+					RecolorSuit(img, suit)
+					InvertColors(img)
+				}
+			} else {
+				draw.Draw(atlas.Source, selected, atlas.Source, cell.Min, draw.Src)
+				InvertColors(atlas.Source.SubImage(selected).(*image.RGBA))
+			}
+		}
+	}
+
+	if theme.Back.Width > 0 {
+		draw.Draw(atlas.Source, image.Rect(0, AtlasBackRow*height, width, (AtlasBackRow+1)*height), theme.Image, image.Pt(theme.Back.X, theme.Back.Y), draw.Src)
+	}
+	for i := 1; i < len(CardBacks); i++ {
+		GenerateBack(atlas.Source.SubImage(image.Rect(i*width, AtlasBackRow*height, (i+1)*width, (AtlasBackRow+1)*height)).(*image.RGBA), &CardBacks[i])
+	}
+
+	return atlas
+}
+
+/*
+ * RecolorSuit paints red diamonds blue and black clubs green, keeping their shades. Hearts and spades are left as they are.
+ * NOTE(anton2920): border of the card is kept black, since it is the same for every suit.
+ */
+func RecolorSuit(img *image.RGBA, suit SuitType) {
+	const border = 2
+
+	for y := border; y < img.Rect.Dy()-border; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+(img.Rect.Dx()-border)*4]
+		for x := border * 4; x < len(row); x += 4 {
+			r, g, b := row[x+0], row[x+1], row[x+2]
+
+			switch suit {
+			case Diamonds:
+				if (r >= 0x80) && (g < 0x60) && (b < 0x60) {
+					row[x+0], row[x+2] = b, r
+				}
+			case Clubs:
+				if (r < 0x40) && (g < 0x40) && (b < 0x40) {
+					row[x+1] = g + 0x80
+				}
+			}
+		}
+	}
+}
+
+func InvertColors(img *image.RGBA) {
+	for y := 0; y < img.Rect.Dy(); y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+img.Rect.Dx()*4]
+		for x := 0; x < len(row); x += 4 {
+			row[x+0] = 0xFF - row[x+0]
+			row[x+1] = 0xFF - row[x+1]
+			row[x+2] = 0xFF - row[x+2]
+		}
+	}
+}
+
+/* Pixmap returns atlas scaled to scale, making and caching it if needed. */
+func (atlas *CardAtlas) Pixmap(scale int) *gr.Pixmap {
+	defer trace.End(trace.Begin(""))
+
+	for i := 0; i < len(atlas.Cache); i++ {
+		if atlas.Cache[i].Scale == scale {
+			cached := atlas.Cache[i]
+			copy(atlas.Cache[1:i+1], atlas.Cache[:i])
+			atlas.Cache[0] = cached
+			return &atlas.Cache[0].Pixmap
+		}
+	}
+
+	if len(atlas.Cache) < CardAtlasCacheSize {
+		atlas.Cache = append(atlas.Cache, ScaledAtlas{})
+	}
+	copy(atlas.Cache[1:], atlas.Cache)
+	atlas.Cache[0] = ScaledAtlas{Scale: scale, Pixmap: gr.NewPixmapFromImage(ScaleAtlas(atlas.Source, scale), gr.AlphaOpaque)}
+
+	return &atlas.Cache[0].Pixmap
+}
+
+/* Update picks card size for window, as options ask. It must be called before game is updated. */
+func (atlas *CardAtlas) Update(window *gui.Window) {
+	if scale := WindowCardScale(window.Width, window.Height, ScalingType(ScalingOption)); scale != CardScale {
+		SetCardScale(scale)
+	}
+}
+
+/*
+ * ScaleAtlas scales every card of src separately, so that cards stay on grid of scaled size and do not bleed into each other.
+ * NOTE(anton2920): cards which grow whole number of times keep pixels sharp, others are filtered.
+ */
+func ScaleAtlas(src *image.RGBA, scale int) *image.RGBA {
+	defer trace.End(trace.Begin(""))
+
+	srcWidth := src.Rect.Dx() / AtlasColumns
+	srcHeight := src.Rect.Dy() / AtlasRows
+	width := ScaleSize(BaseCardWidth, scale)
+	height := ScaleSize(BaseCardHeight, scale)
+	dst := image.NewRGBA(image.Rect(0, 0, AtlasColumns*width, AtlasRows*height))
+
+	for j := 0; j < AtlasRows; j++ {
+		for i := 0; i < AtlasColumns; i++ {
+			x := src.Rect.Min.X + i*srcWidth
+			y := src.Rect.Min.Y + j*srcHeight
+			cell := src.SubImage(image.Rect(x, y, x+srcWidth, y+srcHeight)).(*image.RGBA)
+			out := dst.SubImage(image.Rect(i*width, j*height, (i+1)*width, (j+1)*height)).(*image.RGBA)
+
+			if (width%srcWidth == 0) && (height%srcHeight == 0) {
+				ScaleNearest(out, cell)
+			} else {
+				ScaleBilinear(out, cell)
+			}
+		}
+	}
+
+	return dst
+}
+
+func ScaleNearest(dst, src *image.RGBA) {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+
+	for y := 0; y < dh; y++ {
+		srow := src.Pix[(y*sh/dh)*src.Stride:]
+		drow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			copy(drow[x*4:x*4+4], srow[(x*sw/dw)*4:])
+		}
+	}
+}
+
+/* ScaleBilinear samples src at centres of dst pixels. Coordinates are fixed point with 8 bits of fraction. */
+func ScaleBilinear(dst, src *image.RGBA) {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+
+	for y := 0; y < dh; y++ {
+		sy := max(((2*y+1)*sh*256/dh-256)/2, 0)
+		y0 := sy >> 8
+		y1 := min(y0+1, sh-1)
+		fy := sy & 0xFF
+
+		drow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < dw; x++ {
+			sx := max(((2*x+1)*sw*256/dw-256)/2, 0)
+			x0 := sx >> 8
+			x1 := min(x0+1, sw-1)
+			fx := sx & 0xFF
+
+			for c := 0; c < 4; c++ {
+				p00 := int(src.Pix[y0*src.Stride+x0*4+c])
+				p01 := int(src.Pix[y0*src.Stride+x1*4+c])
+				p10 := int(src.Pix[y1*src.Stride+x0*4+c])
+				p11 := int(src.Pix[y1*src.Stride+x1*4+c])
+
+				top := p00*(256-fx) + p01*fx
+				bottom := p10*(256-fx) + p11*fx
+				drow[x*4+c] = uint8((top*(256-fy) + bottom*fy) >> 16)
+			}
+		}
+	}
+}
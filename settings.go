@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anton2920/gofa/log"
+)
+
+/* SettingsFile is kept in ConfigDir together with variants and themes. */
+const SettingsFile = "settings.txt"
+
+/* ConfigDir is the directory with user's settings, variants and themes. It is empty if there is no such directory. */
+var ConfigDir string
+
+type Settings struct {
+	/* Assets is directory with theme which is used instead of built-in default one. */
+	Assets string
+
+	/* Look holds values of LookOptions by their keys. They are applied once themes are loaded, see ApplyLook. */
+	Look map[string]string
+}
+
+/* LoadSettings reads settings from path. Missing file means that everything is left as default. */
+func LoadSettings(path string) (Settings, error) {
+	var settings Settings
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return settings, err
+	}
+	defer f.Close()
+
+	if err := settings.Parse(f); err != nil {
+		return settings, fmt.Errorf("%s: %w", path, err)
+	}
+
+	/* NOTE(anton2920): relative paths are taken from the directory of settings, not from where the game is started. */
+	if (len(settings.Assets) > 0) && (!filepath.IsAbs(settings.Assets)) {
+		settings.Assets = filepath.Join(filepath.Dir(path), settings.Assets)
+	}
+	return settings, nil
+}
+
+/*
+ * Parse reads "key values..." lines, as variant definitions do:
+ *	assets <directory>
+ *	<key of option> <value>
+ */
+func (settings *Settings) Parse(r io.Reader) error {
+	var lineno int
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineno++
+
+		line := strings.TrimSpace(scanner.Text())
+		if (len(line) == 0) || (line[0] == '#') {
+			continue
+		}
+		fields := strings.Fields(line)
+		if err := settings.Set(fields[0], fields[1:]); err != nil {
+			return fmt.Errorf("line %d: %w", lineno, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (settings *Settings) Set(key string, values []string) error {
+	switch key {
+	default:
+		/* NOTE(anton2920): options are checked by ApplyLook, since values of some of them, like themes, are not known yet. */
+		if len(values) == 0 {
+			return fmt.Errorf("expected value of %q", key)
+		}
+		if settings.Look == nil {
+			settings.Look = make(map[string]string)
+		}
+		settings.Look[key] = strings.Join(values, " ")
+	case "assets":
+		if len(values) == 0 {
+			return fmt.Errorf("expected directory")
+		}
+		settings.Assets = strings.Join(values, " ")
+	}
+	return nil
+}
+
+/* ApplyLook sets options to values from settings. Unknown keys and values are reported and left as default. */
+func (settings *Settings) ApplyLook(options []Option) {
+	for key, value := range settings.Look {
+		option := FindOption(options, key)
+		if option == nil {
+			log.Errorf("Failed to apply settings: unknown key %q", key)
+			continue
+		}
+
+		n := FindValue(option.Values, value)
+		if n == -1 {
+			log.Errorf("Failed to apply settings: unknown value %q of %q", value, key)
+			continue
+		}
+		*option.Value = n
+	}
+}
+
+func FindValue(values []string, value string) int {
+	for i := 0; i < len(values); i++ {
+		if values[i] == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func FindOption(options []Option, key string) *Option {
+	for i := 0; i < len(options); i++ {
+		if (len(options[i].Key) > 0) && (options[i].Key == key) {
+			return &options[i]
+		}
+	}
+	return nil
+}
+
+/* SaveSettings writes settings and current values of options with keys to path, making its directory if needed. */
+func SaveSettings(path string, settings Settings, options []Option) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if len(settings.Assets) > 0 {
+		fmt.Fprintf(w, "assets %s\n", settings.Assets)
+	}
+	for i := 0; i < len(options); i++ {
+		option := &options[i]
+		if len(option.Key) > 0 {
+			fmt.Fprintf(w, "%s %s\n", option.Key, option.Values[*option.Value])
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/anton2920/gofa/trace"
+)
+
+type SolveStatus int
+
+const (
+	SolveUnknown SolveStatus = iota
+	SolveWon
+	SolveLost
+)
+
+/* DefaultSolveLimit is the number of positions solver examines before giving up. */
+const DefaultSolveLimit = 200000
+
+/* Solution is the result of search. Moves are set only when Status is SolveWon. */
+type Solution struct {
+	Status SolveStatus
+	Moves  []Move
+	Nodes  int
+}
+
+type PatienceSolver struct {
+	Limit int
+	Nodes int
+
+	/* Blind solver does not look at face-down cards, so it may not take back move which turns one over. */
+	Blind bool
+	Stuck bool
+
+	/* Stop is set by other goroutine when it is no longer interested in result. It may be nil. */
+	Stop *atomic.Bool
+
+	Visited map[string]struct{}
+	Moves   []Move
+	Key     []byte
+}
+
+var SolveStatusNames = [...]string{
+	SolveUnknown: "unknown",
+	SolveWon:     "winnable",
+	SolveLost:    "not winnable",
+}
+
+func (status SolveStatus) String() string {
+	return SolveStatusNames[status]
+}
+
+func Stopped(stop *atomic.Bool) bool {
+	return (stop != nil) && (stop.Load())
+}
+
+/* SolvePatience searches for sequence of moves which wins the game from its current position. Game itself is not modified. */
+func SolvePatience(game *Patience, limit int) Solution {
+	solver := PatienceSolver{Limit: limit}
+	return solver.Solve(game)
+}
+
+/*
+ * SolvePatienceBlind searches for win like player who does not know face-down cards would do. Solution it finds does not depend on them, so deal is won for sure.
+ * NOTE(anton2920): cards of stock are not hidden from it, since player may go through stock to see them.
+ */
+func SolvePatienceBlind(game *Patience, limit int) Solution {
+	solver := PatienceSolver{Limit: limit, Blind: true}
+	return solver.Solve(game)
+}
+
+func (solver *PatienceSolver) Solve(game *Patience) Solution {
+	defer trace.End(trace.Begin(""))
+
+	solver.Visited = make(map[string]struct{})
+	clone := game.Clone()
+	clone.Window = nil
+
+	status := solver.Search(&clone)
+	if (status == SolveLost) && ((solver.Nodes >= solver.Limit) || (solver.Stuck) || (Stopped(solver.Stop))) {
+		status = SolveUnknown
+	}
+
+	solution := Solution{Status: status, Nodes: solver.Nodes}
+	if status == SolveWon {
+		solution.Moves = solver.Moves
+	}
+	return solution
+}
+
+/* Reveals reports whether move turns over face-down card. */
+func Reveals(game *Patience, move Move) bool {
+	if move.Src == PileIDStock {
+		return (game.Rules.Stock == StockTableau) && (len(game.Stock.Cards) > 0) && (game.Stock.Top().FaceDown)
+	}
+	src := game.Pile(move.Src)
+	return (move.Index > 0) && (src.Cards[move.Index-1].FaceDown)
+}
+
+/* PositionKey identifies position of the game without regard to card coordinates. */
+func (solver *PatienceSolver) PositionKey(game *Patience) string {
+	solver.Key = game.AppendPosition(solver.Key[:0])
+	return string(solver.Key)
+}
+
+/* AppendPosition appends to key everything which matters for the outcome of the game. */
+func (game *Patience) AppendPosition(key []byte) []byte {
+	key = strconv.AppendInt(key, int64(game.Redeals), 10)
+
+	first := PileIDStock
+	if (game.Rules.Stock == StockWaste) && (game.Draw == 1) && (game.Redeals < 0) {
+		/* NOTE(anton2920): when every card of stock may be reached, only order of cards in it matters, not how many of them are in waste. */
+		key = append(key, '|')
+		for i := 0; i < len(game.Waste.Cards); i++ {
+			key = AppendCard(key, &game.Waste.Cards[i])
+		}
+		for i := len(game.Stock.Cards) - 1; i >= 0; i-- {
+			key = AppendCard(key, &game.Stock.Cards[i])
+		}
+		first = PileIDReserve
+	}
+
+	for id := first; id < game.NumPiles(); id++ {
+		pile := game.Pile(id)
+		key = append(key, '|')
+		for i := 0; i < len(pile.Cards); i++ {
+			key = AppendCard(key, &pile.Cards[i])
+		}
+	}
+	return key
+}
+
+/* SolveJob solves copy of the game, so that it may be played while job runs. */
+func (game *Patience) SolveJob() SolveJob {
+	clone := game.Clone()
+	clone.Window = nil
+	return func(stop *atomic.Bool, limit int) Solution {
+		solver := PatienceSolver{Limit: limit, Stop: stop}
+		return solver.Solve(&clone)
+	}
+}
+
+func (solver *PatienceSolver) Search(game *Patience) SolveStatus {
+	if game.Won() {
+		return SolveWon
+	}
+	if (solver.Nodes >= solver.Limit) || (solver.Stuck) || (Stopped(solver.Stop)) {
+		return SolveLost
+	}
+
+	key := solver.PositionKey(game)
+	if _, ok := solver.Visited[key]; ok {
+		return SolveLost
+	}
+	solver.Visited[key] = struct{}{}
+	solver.Nodes++
+
+	moves := game.Moves(nil)
+	if move, ok := SafeFoundationMove(game, moves); ok {
+		return solver.Try(game, []Move{move})
+	}
+	SortMoves(game, moves)
+
+	for i := 0; i < len(moves); i++ {
+		if (moves[i].Src == PileIDStock) && (game.Rules.Stock == StockWaste) {
+			continue
+		}
+		if IsPointlessMove(game, moves[i]) {
+			continue
+		}
+		if status := solver.Try(game, moves[i:i+1]); status == SolveWon {
+			return status
+		}
+	}
+
+	/* NOTE(anton2920): dealing cards to waste is only useful when waste card is played afterwards, so these are searched together. */
+	if (game.Rules.Stock == StockWaste) && (game.CanDealFromStock()) {
+		var deals []Move
+
+		/*
+		 * NOTE(anton2920): dealing and turning waste over keep order of cards, so after going through stock once positions repeat.
+		 * With draw of several cards they may repeat shifted, so waste never gets back to its length, and number of deals is limited instead.
+		 */
+		maxDeals := (len(game.Stock.Cards)+len(game.Waste.Cards)+game.Draw-1)/game.Draw + 1
+
+		stock := game.Clone()
+		for (stock.CanDealFromStock()) && (len(deals) < maxDeals) {
+			if (solver.Nodes >= solver.Limit) || (solver.Stuck) || (Stopped(solver.Stop)) {
+				break
+			}
+
+			stock.MakeMove(Move{Src: PileIDStock})
+			deals = append(deals, Move{Src: PileIDStock})
+			if len(stock.Waste.Cards) == len(game.Waste.Cards) {
+				break
+			}
+
+			moves = stock.Moves(moves[:0])
+			for j := 0; j < len(moves); j++ {
+				if moves[j].Src != PileIDWaste {
+					continue
+				}
+				if status := solver.Try(game, append(deals, moves[j])); status == SolveWon {
+					return status
+				}
+			}
+		}
+	}
+
+	return SolveLost
+}
+
+/* Try makes moves on copy of the game and searches from there. */
+func (solver *PatienceSolver) Try(game *Patience, moves []Move) SolveStatus {
+	if solver.Stuck {
+		return SolveLost
+	}
+
+	next := game.Clone()
+	for i := 0; i < len(moves); i++ {
+		next.MakeMove(moves[i])
+	}
+
+	solver.Moves = append(solver.Moves, moves...)
+	status := solver.Search(&next)
+	if status != SolveWon {
+		solver.Moves = solver.Moves[:len(solver.Moves)-len(moves)]
+
+		/* NOTE(anton2920): choosing other move after seeing what is under the card would need knowing it in advance. */
+		if (solver.Blind) && (len(moves) == 1) && (Reveals(game, moves[0])) {
+			solver.Stuck = true
+		}
+	}
+	return status
+}
+
+/* MovePriority is lower for moves which are more likely to lead to win. */
+func MovePriority(game *Patience, move Move) int {
+	if move.Src == PileIDStock {
+		return 4
+	}
+
+	src := game.Pile(move.Src)
+	dst := game.Pile(move.Dst)
+	switch {
+	case dst.Type == PileFoundation:
+		return 0
+	case src.Type == PileFoundation:
+		return 3
+	case (src.Type == PileTableau) && (move.Index > 0) && (src.Cards[move.Index-1].FaceDown):
+		return 1
+	}
+	return 2
+}
+
+/*
+ * IsPointlessMove reports whether move between columns only changes the place of cards.
+ * NOTE(anton2920): run which already sits on proper card is moved only to play the card beneath to foundation. This misses rare wins, but without it search wanders around forever.
+ */
+func IsPointlessMove(game *Patience, move Move) bool {
+	if move.Src == PileIDStock {
+		return false
+	}
+
+	src := game.Pile(move.Src)
+	dst := game.Pile(move.Dst)
+	if (src.Type != PileTableau) || (dst.Type == PileFoundation) {
+		return false
+	}
+	if move.Index == 0 {
+		return len(dst.Cards) == 0
+	}
+
+	under := &src.Cards[move.Index-1]
+	if (under.FaceDown) || (!game.Rules.CanBuild(&src.Cards[move.Index], under)) {
+		return false
+	}
+	for i := 0; i < len(game.Foundations); i++ {
+		if (!game.Rules.FoundationRuns) && (CanMove2Foundation(under, game.Foundations[i].Top(), game.BaseValue, game.Rules.Wrap)) {
+			return false
+		}
+	}
+	return true
+}
+
+func SortMoves(game *Patience, moves []Move) {
+	for i := 1; i < len(moves); i++ {
+		for j := i; (j > 0) && (MovePriority(game, moves[j]) < MovePriority(game, moves[j-1])); j-- {
+			moves[j], moves[j-1] = moves[j-1], moves[j]
+		}
+	}
+}
+
+/*
+ * SafeFoundationMove returns move to foundation which never needs to be undone.
+ * Card is safe to put there when every card of opposite colour it could hold on the table is already in foundations.
+ */
+func SafeFoundationMove(game *Patience, moves []Move) (Move, bool) {
+	rules := game.Rules
+	if (rules.Decks != 1) || (rules.Build != BuildAlternate) || (rules.Direction != BuildDown) || (rules.Base != Ace) || (rules.DealBase) || (rules.Wrap) || (rules.FoundationRuns) {
+		return Move{}, false
+	}
+
+	var tops [Aces + 1]ValueType
+	for i := 0; i < len(game.Foundations); i++ {
+		if top := game.Foundations[i].Top(); top != nil {
+			tops[top.Suit] = top.Value
+		}
+	}
+
+	for i := 0; i < len(moves); i++ {
+		move := moves[i]
+		if (move.Src == PileIDStock) || (game.Pile(move.Dst).Type != PileFoundation) {
+			continue
+		}
+
+		card := game.Pile(move.Src).Top()
+		if card.Value <= Ace+1 {
+			return move, true
+		}
+		safe := true
+		for suit := Clubs; suit <= Aces; suit++ {
+			other := Card{Suit: suit}
+			if (other.Red() != card.Red()) && (tops[suit] < card.Value-1) {
+				safe = false
+			}
+		}
+		if safe {
+			return move, true
+		}
+	}
+	return Move{}, false
+}
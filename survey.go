@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anton2920/gofa/log"
+)
+
+/* DefaultSurveyLimit is large enough for solver to prove that deal can not be won. */
+const DefaultSurveyLimit = 1000000
+
+type SurveyResult struct {
+	Deal     int
+	Solution Solution
+	Elapsed  time.Duration
+
+	/* Rating is set only when survey rates deals. */
+	Rating *DealRating
+}
+
+const (
+	SurveyHeader      = "deal,solvable,length,nodes,milliseconds\n"
+	SurveyRatedHeader = "deal,solvable,length,nodes,milliseconds,cells,shortest,score,difficulty\n"
+)
+
+var SurveyAnswers = [...]string{
+	SolveUnknown: "unknown",
+	SolveWon:     "yes",
+	SolveLost:    "no",
+}
+
+/*
+ * Survey solves FreeCell deals from range and writes one line of CSV for each of them. With -rate it also rates deals the way new games are rated, see RateFreeCell.
+ * Results are written in order in which they are found. Deals which are already in the file are skipped, so interrupted survey continues where it stopped.
+ * Usage: solitaire survey [-o file.csv] [-j workers] [-limit nodes] [-game name] [-rate] first..last
+ */
+func Survey(args []string) error {
+	flags := flag.NewFlagSet("survey", flag.ContinueOnError)
+	output := flags.String("o", "survey.csv", "CSV `file` to write results to")
+	workers := flags.Int("j", runtime.NumCPU(), "number of deals solved at once")
+	limit := flags.Int("limit", DefaultSurveyLimit, "number of positions solver examines before giving up")
+	name := flags.String("game", "FreeCell", "name of the game, which must be played by freecell engine")
+	rate := flags.Bool("rate", false, "rate difficulty of deals, which takes several times longer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected range of deals, like 1..32000")
+	}
+	first, last, err := ParseRange(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *workers < 1 {
+		return fmt.Errorf("number of workers must be positive, got %d", *workers)
+	}
+
+	entry := FindGame(*name)
+	if entry == nil {
+		return fmt.Errorf("unknown game %q", *name)
+	}
+	game, ok := entry.New(nil, nil, nil, nil).(*FreeCell)
+	if !ok {
+		return fmt.Errorf("game %q is not played by freecell engine", *name)
+	}
+	rules := game.Rules
+
+	f, err := os.OpenFile(*output, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer f.Close()
+
+	done, offset, err := ReadSurvey(f)
+	if err != nil {
+		return fmt.Errorf("failed to read previous results: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	if offset == 0 {
+		if *rate {
+			w.WriteString(SurveyRatedHeader)
+		} else {
+			w.WriteString(SurveyHeader)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	deals := make(chan int)
+	results := make(chan SurveyResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for N := range deals {
+				start := time.Now()
+				result := SurveyResult{Deal: N}
+				if *rate {
+					rating := RateFreeCell(DealFreeCellPosition(rules, N), *limit, nil)
+					result.Solution = rating.Solution
+					result.Rating = &rating
+				} else {
+					result.Solution = SolveFreeCellPosition(DealFreeCellPosition(rules, N), *limit, nil)
+				}
+				result.Elapsed = time.Since(start)
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		defer close(deals)
+		for N := first; N <= last; N++ {
+			if _, ok := done[N]; ok {
+				continue
+			}
+			select {
+			case deals <- N:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var solved, lost, unknown int
+	for result := range results {
+		switch result.Solution.Status {
+		case SolveWon:
+			solved++
+		case SolveLost:
+			lost++
+			log.Infof("Deal #%d can not be won", result.Deal)
+		case SolveUnknown:
+			unknown++
+		}
+		WriteSurveyResult(w, &result)
+
+		/* NOTE(anton2920): every line is written at once, so that nothing is lost when survey is killed. */
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to write results: %w", err)
+		}
+		if total := solved + lost + unknown; total%1000 == 0 {
+			log.Infof("Solved %d deals: %d won, %d lost, %d unknown", total, solved, lost, unknown)
+		}
+	}
+	log.Infof("Finished survey of %d deals: %d won, %d lost, %d unknown, %d were done before", solved+lost+unknown, solved, lost, unknown, len(done))
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("survey was interrupted, run it again to continue")
+	}
+	return nil
+}
+
+/* ParseRange parses "first..last" or a single deal number. */
+func ParseRange(s string) (int, int, error) {
+	firstString, lastString, ok := strings.Cut(s, "..")
+	if !ok {
+		lastString = firstString
+	}
+
+	first, err := strconv.Atoi(firstString)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid first deal: %w", err)
+	}
+	last, err := strconv.Atoi(lastString)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid last deal: %w", err)
+	}
+	if (first < 1) || (last < first) {
+		return 0, 0, fmt.Errorf("invalid range of deals %d..%d", first, last)
+	}
+	return first, last, nil
+}
+
+/* ReadSurvey returns deals which are already in the file and leaves f positioned after the last complete line, which offset points to. */
+func ReadSurvey(f *os.File) (map[int]struct{}, int64, error) {
+	done := make(map[int]struct{})
+
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			/* NOTE(anton2920): incomplete line is left by survey which has been killed while writing it. */
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		offset += int64(len(line))
+
+		if (line == SurveyHeader) || (line == SurveyRatedHeader) {
+			continue
+		}
+		deal, _, _ := strings.Cut(line, ",")
+		N, err := strconv.Atoi(deal)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid line %q", strings.TrimSpace(line))
+		}
+		done[N] = struct{}{}
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		return nil, 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return done, offset, nil
+}
+
+func WriteSurveyResult(w io.Writer, result *SurveyResult) {
+	fmt.Fprintf(w, "%d,%s,%d,%d,%d", result.Deal, SurveyAnswers[result.Solution.Status], len(result.Solution.Moves), result.Solution.Nodes, result.Elapsed.Milliseconds())
+	if rating := result.Rating; rating != nil {
+		if result.Solution.Status == SolveWon {
+			fmt.Fprintf(w, ",%d,%d,%d,%s", rating.Cells, rating.Length, rating.Score, rating.Difficulty())
+		} else {
+			io.WriteString(w, ",,,,")
+		}
+	}
+	io.WriteString(w, "\n")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/color"
+	"github.com/anton2920/gofa/gui/gr"
+	"github.com/anton2920/gofa/trace"
+)
+
+type TilePattern int
+
+const (
+	TileNone TilePattern = iota
+	TileFelt
+	TileWood
+	TileTheme
+)
+
+/* Table is drawn under cards. Places for cards are outlined with Dark at top left and Light at bottom right, so that they look pressed in. */
+type Table struct {
+	Name       string
+	Background RGB
+	Dark       color.Color
+	Light      color.Color
+
+	/* Tile is repeated over Background. Theme without tile leaves table filled with Background. */
+	Tile TilePattern
+}
+
+/* TileSize is the size of generated tiles. They are not scaled with cards, so that texture looks the same in every window. */
+const TileSize = 64
+
+var Tables = [...]Table{
+	{"green", RGB{0, 127, 0}, color.Black, color.Green, TileNone},
+
+	/* NOTE(anton2920): high contrast table keeps cards and outlines apart from background for those who can not tell green from red. */
+	{"high contrast", RGB{0, 0, 0}, color.White, color.RGB(0xFF, 0xFF, 0), TileNone},
+
+	{"blue", RGB{0, 64, 128}, color.Black, color.RGB(0, 128, 255), TileNone},
+	{"burgundy", RGB{96, 0, 32}, color.Black, color.RGB(192, 64, 96), TileNone},
+	{"green felt", RGB{0, 112, 0}, color.Black, color.Green, TileFelt},
+	{"wood", RGB{120, 72, 32}, color.Black, color.RGB(208, 160, 96), TileWood},
+	{"from theme", RGB{0, 127, 0}, color.Black, color.Green, TileTheme},
+}
+
+var TableNames []string
+
+var (
+	TableOption  int
+	AppliedTable = -1
+
+	/* TableTile is repeated over the table by DrawTable. It is empty when table is filled with colour only. */
+	TableTile gr.Pixmap
+)
+
+func init() {
+	for i := 0; i < len(Tables); i++ {
+		TableNames = append(TableNames, Tables[i].Name)
+	}
+}
+
+func CurrentTable() *Table {
+	return &Tables[TableOption]
+}
+
+/* GenerateTile draws texture of table, which fits with itself when repeated. */
+func GenerateTile(table *Table) *image.RGBA {
+	defer trace.End(trace.Begin(""))
+
+	img := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
+
+	/* NOTE(anton2920): noise is made by LCG with fixed seed, so that table does not change between runs. */
+	seed := uint32(2920)
+	for y := 0; y < TileSize; y++ {
+		for x := 0; x < TileSize; x++ {
+			seed = seed*1664525 + 1013904223
+			noise := int(seed>>24)%16 - 8
+
+			var shade int
+			switch table.Tile {
+			case TileFelt:
+				shade = noise
+			case TileWood:
+				/* Grain runs along x, rings repeat eight times per tile. */
+				ring := y % (TileSize / 8)
+				shade = noise/2 - ring*2
+			}
+
+			c := table.Background
+			i := img.PixOffset(x, y)
+			img.Pix[i+0] = uint8(min(max(int(c.R)+shade, 0), 0xFF))
+			img.Pix[i+1] = uint8(min(max(int(c.G)+shade, 0), 0xFF))
+			img.Pix[i+2] = uint8(min(max(int(c.B)+shade, 0), 0xFF))
+			img.Pix[i+3] = 0xFF
+		}
+	}
+	return img
+}
+
+/* UpdateTable makes tile of table chosen in options. It is called by UpdateTheme, since tile may come from theme. */
+func UpdateTable() {
+	if TableOption == AppliedTable {
+		return
+	}
+	AppliedTable = TableOption
+
+	table := CurrentTable()
+	switch table.Tile {
+	case TileNone:
+		TableTile = gr.Pixmap{}
+	case TileTheme:
+		if CurrentTheme.Tile.Width > 0 {
+			TableTile = CurrentTheme.Tile.Sub(&CurrentTheme.Pixmap)
+		} else {
+			TableTile = gr.Pixmap{}
+		}
+	default:
+		TableTile = gr.NewPixmapFromImage(GenerateTile(table), gr.AlphaOpaque)
+	}
+}
+
+/* DrawTable fills window with colour of table and repeats its tile over it. */
+func DrawTable(window *gui.Window, renderer gui.Renderer) {
+	defer trace.End(trace.Begin(""))
+
+	renderer.Clear(CurrentTable().Background.Color())
+	if (TableTile.Width == 0) || (TableTile.Height == 0) {
+		return
+	}
+
+	for y := 0; y < window.Height; y += TableTile.Height {
+		for x := 0; x < window.Width; x += TableTile.Width {
+			renderer.RenderPixmap(TableTile, x, y)
+		}
+	}
+}
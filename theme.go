@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anton2920/gofa/gui/gr"
+	"github.com/anton2920/gofa/log"
+)
+
+/* ThemeManifest is the file in theme directory which describes where sprites are in atlas, see ParseTheme. */
+const ThemeManifest = "theme.txt"
+
+type Sprite struct {
+	X, Y          int
+	Width, Height int
+}
+
+/* Cursor is drawn so that its hot spot is under mouse. */
+type Cursor struct {
+	Sprite
+	HotX, HotY int
+}
+
+type Theme struct {
+	Name  string
+	Atlas string
+
+	Image  *image.RGBA
+	Pixmap gr.Pixmap
+
+	/* Cards is ace of clubs. Other values follow it to the right and other suits go down in order of SuitType. Without it cards are drawn. */
+	Cards Sprite
+
+	/* Selected is selected ace of clubs, other selected cards are laid out as Cards. Without it selected cards are drawn inverted. */
+	Selected Sprite
+
+	/* Back is the back of a card. Without it the first of generated CardBacks is used instead. */
+	Back Sprite
+
+	/* Tile is repeated over the table when "from theme" table is chosen. */
+	Tile Sprite
+
+	CursorUp   Cursor
+	CursorDown Cursor
+
+	/* Face looks left and right, see FreeCell.FaceDirection. GiantFace is shown when FreeCell is won. */
+	Face      [2]Sprite
+	GiantFace Sprite
+}
+
+var (
+	Themes     []*Theme
+	ThemeNames []string
+
+	/* ThemeOption is index of theme in Themes, it is applied by UpdateTheme together with SuitColorOption. */
+	ThemeOption      int
+	AppliedTheme     = -1
+	AppliedSuitColor = -1
+
+	/* CurrentTheme is copied from Themes, so that games may keep pointer to its Pixmap. */
+	CurrentTheme Theme
+)
+
+/* BuiltinAssets hold default theme, so that game does not depend on directory it is started from. */
+//go:embed assets
+var BuiltinAssets embed.FS
+
+func (sprite Sprite) Sub(assets *gr.Pixmap) gr.Pixmap {
+	return assets.Sub(sprite.X, sprite.Y, sprite.X+sprite.Width, sprite.Y+sprite.Height)
+}
+
+func (sprite Sprite) Rect() image.Rectangle {
+	return image.Rect(sprite.X, sprite.Y, sprite.X+sprite.Width, sprite.Y+sprite.Height)
+}
+
+func RegisterTheme(theme *Theme) {
+	Themes = append(Themes, theme)
+	ThemeNames = append(ThemeNames, theme.Name)
+}
+
+func FindTheme(name string) *Theme {
+	for i := 0; i < len(Themes); i++ {
+		if Themes[i].Name == name {
+			return Themes[i]
+		}
+	}
+	return nil
+}
+
+/* LoadDefaultTheme loads theme from dir, which overrides built-in one. If dir is empty or theme in it is invalid, built-in theme is used. */
+func LoadDefaultTheme(dir string) *Theme {
+	if len(dir) > 0 {
+		theme, err := LoadTheme(os.DirFS(dir))
+		if err == nil {
+			return theme
+		}
+		log.Errorf("Failed to load assets from %q, using built-in ones: %v", dir, err)
+	}
+
+	assets, err := fs.Sub(BuiltinAssets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	theme, err := LoadTheme(assets)
+	if err != nil {
+		panic(fmt.Sprintf("built-in theme is invalid: %v", err))
+	}
+	return theme
+}
+
+/* LoadThemes registers every valid theme from subdirectories of dir. Invalid ones are reported and skipped. */
+func LoadThemes(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Failed to list themes in %q: %v", dir, err)
+		}
+		return
+	}
+
+	for i := 0; i < len(entries); i++ {
+		if !entries[i].IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entries[i].Name())
+
+		theme, err := LoadTheme(os.DirFS(path))
+		if err != nil {
+			log.Errorf("Failed to load theme %q: %v", path, err)
+			continue
+		}
+		if FindTheme(theme.Name) != nil {
+			log.Errorf("Failed to load theme %q: theme %q already exists", path, theme.Name)
+			continue
+		}
+		RegisterTheme(theme)
+	}
+}
+
+/* LoadTheme reads manifest and atlas of theme from fsys. */
+func LoadTheme(fsys fs.FS) (*Theme, error) {
+	f, err := fsys.Open(ThemeManifest)
+	if err != nil {
+		return nil, err
+	}
+	theme, err := ParseTheme(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ThemeManifest, err)
+	}
+
+	f, err = fsys.Open(theme.Atlas)
+	if err != nil {
+		return nil, err
+	}
+	atlas, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", theme.Atlas, err)
+	}
+	theme.Image = Image2RGBA(atlas)
+
+	if err := theme.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ThemeManifest, err)
+	}
+	theme.Pixmap = gr.NewPixmapFromImage(theme.Image, gr.AlphaOpaque)
+
+	return theme, nil
+}
+
+/*
+ * ParseTheme reads manifest which consists of "key values..." lines, as variant definitions do. Places are in pixels of atlas, see assets/ for example:
+ *	name <text>
+ *	atlas <PNG file in theme directory>
+ *	cards <x> <y> <width> <height>
+ *	selected, back <x> <y>
+ *	cursor-up, cursor-down <x> <y> <width> <height> <hot x> <hot y>
+ *	face-left, face-right, giant-face, tile <x> <y> <width> <height>
+ * Cards, selected, back and tile are optional, the rest is required. Theme without cards has them drawn, see DrawnCard.
+ */
+func ParseTheme(r io.Reader) (*Theme, error) {
+	var lineno int
+
+	theme := new(Theme)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineno++
+
+		line := strings.TrimSpace(scanner.Text())
+		if (len(line) == 0) || (line[0] == '#') {
+			continue
+		}
+		fields := strings.Fields(line)
+		if err := theme.Set(fields[0], fields[1:]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(theme.Name) == 0 {
+		return nil, fmt.Errorf("name is not set")
+	}
+	if len(theme.Atlas) == 0 {
+		return nil, fmt.Errorf("atlas is not set")
+	}
+	return theme, nil
+}
+
+func ParseSprite(values []string, n int) ([]int, error) {
+	if len(values) != n {
+		return nil, fmt.Errorf("expected %d numbers, got %d", n, len(values))
+	}
+	return ParseInts(values)
+}
+
+func (theme *Theme) Set(key string, values []string) error {
+	var ns []int
+	var err error
+
+	switch key {
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	case "name":
+		if len(values) == 0 {
+			return fmt.Errorf("name is empty")
+		}
+		theme.Name = strings.Join(values, " ")
+	case "atlas":
+		if len(values) != 1 {
+			return fmt.Errorf("expected one file name")
+		}
+		theme.Atlas = values[0]
+	case "cards", "face-left", "face-right", "giant-face", "tile":
+		if ns, err = ParseSprite(values, 4); err != nil {
+			return err
+		}
+		sprite := Sprite{ns[0], ns[1], ns[2], ns[3]}
+
+		switch key {
+		case "cards":
+			theme.Cards = sprite
+		case "face-left":
+			theme.Face[0] = sprite
+		case "face-right":
+			theme.Face[1] = sprite
+		case "giant-face":
+			theme.GiantFace = sprite
+		case "tile":
+			theme.Tile = sprite
+		}
+	case "selected", "back":
+		if ns, err = ParseSprite(values, 2); err != nil {
+			return err
+		}
+
+		/* NOTE(anton2920): size is taken from cards once whole manifest is read. */
+		sprite := Sprite{X: ns[0], Y: ns[1], Width: -1, Height: -1}
+		if key == "selected" {
+			theme.Selected = sprite
+		} else {
+			theme.Back = sprite
+		}
+	case "cursor-up", "cursor-down":
+		if ns, err = ParseSprite(values, 6); err != nil {
+			return err
+		}
+		cursor := Cursor{Sprite{ns[0], ns[1], ns[2], ns[3]}, ns[4], ns[5]}
+
+		if key == "cursor-up" {
+			theme.CursorUp = cursor
+		} else {
+			theme.CursorDown = cursor
+		}
+	}
+	return nil
+}
+
+/* Validate checks that every sprite is set and lies inside of atlas. */
+func (theme *Theme) Validate() error {
+	if ((theme.Cards.Width == 0) || (theme.Cards.Height == 0)) && ((theme.Selected.Width != 0) || (theme.Back.Width != 0)) {
+		return fmt.Errorf("selected and back need cards to be set")
+	}
+	if theme.Selected.Width < 0 {
+		theme.Selected.Width, theme.Selected.Height = theme.Cards.Width, theme.Cards.Height
+	}
+	if theme.Back.Width < 0 {
+		theme.Back.Width, theme.Back.Height = theme.Cards.Width, theme.Cards.Height
+	}
+
+	bounds := theme.Image.Bounds()
+	sprites := [...]struct {
+		Name    string
+		Sprite  Sprite
+		Columns int
+		Rows    int
+	}{
+		{"cards", theme.Cards, AtlasColumns, 4},
+		{"selected", theme.Selected, AtlasColumns, 4},
+		{"back", theme.Back, 1, 1},
+		{"cursor-up", theme.CursorUp.Sprite, 1, 1},
+		{"cursor-down", theme.CursorDown.Sprite, 1, 1},
+		{"face-left", theme.Face[0], 1, 1},
+		{"face-right", theme.Face[1], 1, 1},
+		{"giant-face", theme.GiantFace, 1, 1},
+		{"tile", theme.Tile, 1, 1},
+	}
+	for i := 0; i < len(sprites); i++ {
+		name, sprite := sprites[i].Name, sprites[i].Sprite
+		if ((name == "cards") || (name == "selected") || (name == "back") || (name == "tile")) && (sprite.Width == 0) {
+			continue
+		}
+		if (sprite.Width <= 0) || (sprite.Height <= 0) {
+			return fmt.Errorf("%s is not set", name)
+		}
+
+		sprite.Width *= sprites[i].Columns
+		sprite.Height *= sprites[i].Rows
+		if !sprite.Rect().In(bounds) {
+			return fmt.Errorf("%s at %v is outside of atlas of size %dx%d", name, sprite.Rect(), bounds.Dx(), bounds.Dy())
+		}
+	}
+	return nil
+}
+
+/* UpdateTheme applies theme, colours of suits and table chosen in options. It must be called before game is updated. */
+func UpdateTheme() {
+	if (ThemeOption != AppliedTheme) || (SuitColorOption != AppliedSuitColor) {
+		AppliedTheme = ThemeOption
+		AppliedSuitColor = SuitColorOption
+
+		fourColor := SuitColorOption == 1
+		if fourColor {
+			Palette = &FourColorPalette
+		} else {
+			Palette = &TwoColorPalette
+		}
+		CurrentTheme = *Themes[ThemeOption]
+		CardSprites = NewCardAtlas(&CurrentTheme, fourColor)
+
+		/* NOTE(anton2920): table may be tiled with sprite from theme, so it is made again. */
+		AppliedTable = -1
+	}
+	UpdateTable()
+}
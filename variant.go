@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/gui"
+	"github.com/anton2920/gofa/gui/gr"
+	"github.com/anton2920/gofa/log"
+)
+
+type EngineType int
+
+const (
+	/* EnginePatience plays any rules with Patience. */
+	EnginePatience EngineType = iota
+
+	/* EngineFreeCell plays rules with FreeCell, which deals cards like Microsoft FreeCell does. */
+	EngineFreeCell
+)
+
+type DirectionType int
+
+const (
+	BuildDown DirectionType = iota
+	BuildUp
+	BuildBoth
+)
+
+type GrabRule int
+
+const (
+	/* GrabRun allows to pick up only cards that are built on each other. */
+	GrabRun GrabRule = iota
+
+	/* GrabAny allows to pick up any face-up card together with everything on top of it. */
+	GrabAny
+)
+
+type EmptyRule int
+
+const (
+	EmptyAny EmptyRule = iota
+	EmptyKings
+	EmptyNone
+)
+
+type StockRule int
+
+const (
+	StockNone StockRule = iota
+
+	/* StockWaste deals Draw cards from stock to waste. */
+	StockWaste
+
+	/* StockTableau deals one card from stock to each column. */
+	StockTableau
+)
+
+type Rules struct {
+	Name   string
+	Engine EngineType
+
+	Decks   int
+	Columns int
+	Cells   int
+
+	/* Number of face-down and face-up cards dealt to each column. */
+	DealDown []int
+	DealUp   []int
+
+	/* Open means that every card is dealt face-up, including stock. */
+	Open bool
+
+	/* Number of cards dealt to reserve, only top one of which is face-up. */
+	Reserve int
+
+	/* FillFromReserve means that empty columns are filled from reserve automatically. */
+	FillFromReserve bool
+
+	/* Base is the value foundations start from. With DealBase a card is dealt to first foundation and its value is used instead. */
+	Base     ValueType
+	DealBase bool
+
+	/* FoundationRuns means that only complete King to Ace runs of the same suit go to foundations. */
+	FoundationRuns bool
+
+	/* FromFoundation allows to move top card of foundation back onto the tableau. */
+	FromFoundation bool
+
+	Build     BuildRule
+	Direction DirectionType
+	Grab      GrabRule
+	Empty     EmptyRule
+
+	/* Wrap allows to build King on Ace and Ace on King. */
+	Wrap bool
+
+	/* Supermove means that cards are moved one at a time, so run can only be moved with help of free cells and empty columns. */
+	Supermove bool
+
+	Stock StockRule
+
+	/* Number of cards dealt from stock to waste at once. */
+	Draw int
+
+	/* Number of times waste may be turned over into stock, -1 means unlimited. */
+	Redeals int
+
+	/* StockOptions lets player choose Draw and Redeals in options, as Klondike is usually played. Values of the variant are chosen by default. */
+	StockOptions bool
+}
+
+//go:embed variants/*.txt
+var BuiltinVariants embed.FS
+
+func init() {
+	entries, err := BuiltinVariants.ReadDir("variants")
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < len(entries); i++ {
+		name := "variants/" + entries[i].Name()
+
+		f, err := BuiltinVariants.Open(name)
+		if err != nil {
+			panic(err)
+		}
+		rules, err := ParseRules(f)
+		f.Close()
+		if err != nil {
+			panic(fmt.Sprintf("built-in variant %s is invalid: %v", name, err))
+		}
+		RegisterRules(rules)
+	}
+}
+
+/* RegisterRules registers game which is played by rules. */
+func RegisterRules(rules *Rules) {
+	switch rules.Engine {
+	case EnginePatience:
+		RegisterGame(rules.Name, func(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *gr.Pixmap) Game {
+			game := NewPatience(window, renderer, ui, assets, rules)
+			return &game
+		})
+	case EngineFreeCell:
+		RegisterGame(rules.Name, func(window *gui.Window, renderer gui.Renderer, ui *gui.UI, assets *gr.Pixmap) Game {
+			game := NewFreeCell(window, renderer, ui, assets, rules)
+			return &game
+		})
+	}
+}
+
+/* LoadVariants registers every valid definition from dir. Invalid ones are reported and skipped. */
+func LoadVariants(dir string) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		log.Errorf("Failed to list variants in %q: %v", dir, err)
+		return
+	}
+
+	for i := 0; i < len(paths); i++ {
+		rules, err := LoadRules(paths[i])
+		if err != nil {
+			log.Errorf("Failed to load variant: %v", err)
+			continue
+		}
+		if FindGame(rules.Name) != nil {
+			log.Errorf("Failed to load variant %q: game %q already exists", paths[i], rules.Name)
+			continue
+		}
+		RegisterRules(rules)
+	}
+}
+
+func LoadRules(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+/*
+ * ParseRules reads definition which consists of "key values..." lines. Empty lines and lines starting with '#' are ignored.
+ * Keys are named after fields of Rules, see variants/ for examples:
+ *	name <text>
+ *	engine patience|freecell
+ *	decks, columns, cells, reserve, draw <number>
+ *	deal-down, deal-up <number for each column>
+ *	base A..K|dealt
+ *	foundation cards|runs
+ *	build alternate|suit|any
+ *	direction down|up|both
+ *	grab run|any
+ *	empty any|kings|none
+ *	stock none|waste|tableau
+ *	redeals <number>|unlimited
+ *	fill-from-reserve, from-foundation, open, wrap, supermove, stock-options yes|no
+ */
+func ParseRules(r io.Reader) (*Rules, error) {
+	var lineno int
+
+	rules := new(Rules)
+	rules.Decks = 1
+	rules.Base = Ace
+	rules.Draw = 1
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineno++
+
+		line := strings.TrimSpace(scanner.Text())
+		if (len(line) == 0) || (line[0] == '#') {
+			continue
+		}
+		fields := strings.Fields(line)
+		if err := rules.Set(fields[0], fields[1:]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func ParseWord(values []string, words ...string) (int, error) {
+	if len(values) != 1 {
+		return 0, fmt.Errorf("expected one of %s", strings.Join(words, ", "))
+	}
+	for i := 0; i < len(words); i++ {
+		if values[0] == words[i] {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("expected one of %s, got %q", strings.Join(words, ", "), values[0])
+}
+
+func ParseBool(values []string) (bool, error) {
+	yes, err := ParseWord(values, "no", "yes")
+	return yes == 1, err
+}
+
+func ParseInts(values []string) ([]int, error) {
+	ns := make([]int, len(values))
+	for i := 0; i < len(values); i++ {
+		n, err := strconv.Atoi(values[i])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("expected non-negative number, got %d", n)
+		}
+		ns[i] = n
+	}
+	return ns, nil
+}
+
+func ParseValue(s string) (ValueType, error) {
+	for i := int(Ace); i <= int(King); i++ {
+		if (len(s) == 1) && (ValueChars[i] == s[0]) {
+			return ValueType(i), nil
+		}
+	}
+	return None, fmt.Errorf("invalid value %q", s)
+}
+
+func (rules *Rules) Set(key string, values []string) error {
+	var n int
+	var err error
+
+	switch key {
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	case "name":
+		if len(values) == 0 {
+			return fmt.Errorf("name is empty")
+		}
+		rules.Name = strings.Join(values, " ")
+	case "engine":
+		n, err = ParseWord(values, "patience", "freecell")
+		rules.Engine = EngineType(n)
+	case "decks":
+		rules.Decks, err = ParseInt(values)
+	case "columns":
+		rules.Columns, err = ParseInt(values)
+	case "cells":
+		rules.Cells, err = ParseInt(values)
+	case "deal-down":
+		rules.DealDown, err = ParseInts(values)
+	case "deal-up":
+		rules.DealUp, err = ParseInts(values)
+	case "reserve":
+		rules.Reserve, err = ParseInt(values)
+	case "fill-from-reserve":
+		rules.FillFromReserve, err = ParseBool(values)
+	case "base":
+		if (len(values) == 1) && (values[0] == "dealt") {
+			rules.DealBase = true
+		} else if len(values) == 1 {
+			rules.Base, err = ParseValue(values[0])
+		} else {
+			err = fmt.Errorf("expected value or dealt")
+		}
+	case "foundation":
+		n, err = ParseWord(values, "cards", "runs")
+		rules.FoundationRuns = n == 1
+	case "build":
+		n, err = ParseWord(values, "alternate", "suit", "any")
+		rules.Build = BuildRule(n)
+	case "direction":
+		n, err = ParseWord(values, "down", "up", "both")
+		rules.Direction = DirectionType(n)
+	case "from-foundation":
+		rules.FromFoundation, err = ParseBool(values)
+	case "open":
+		rules.Open, err = ParseBool(values)
+	case "wrap":
+		rules.Wrap, err = ParseBool(values)
+	case "grab":
+		n, err = ParseWord(values, "run", "any")
+		rules.Grab = GrabRule(n)
+	case "empty":
+		n, err = ParseWord(values, "any", "kings", "none")
+		rules.Empty = EmptyRule(n)
+	case "supermove":
+		rules.Supermove, err = ParseBool(values)
+	case "stock":
+		n, err = ParseWord(values, "none", "waste", "tableau")
+		rules.Stock = StockRule(n)
+	case "draw":
+		rules.Draw, err = ParseInt(values)
+	case "redeals":
+		if (len(values) == 1) && (values[0] == "unlimited") {
+			rules.Redeals = -1
+		} else {
+			rules.Redeals, err = ParseInt(values)
+		}
+	case "stock-options":
+		rules.StockOptions, err = ParseBool(values)
+	}
+
+	return err
+}
+
+func (rules *Rules) Validate() error {
+	if len(rules.Name) == 0 {
+		return fmt.Errorf("name is not set")
+	}
+	if (rules.Decks < 1) || (rules.Decks > 4) {
+		return fmt.Errorf("number of decks must be from 1 to 4, got %d", rules.Decks)
+	}
+	if (rules.Columns < 1) || (rules.Columns > 13) {
+		return fmt.Errorf("number of columns must be from 1 to 13, got %d", rules.Columns)
+	}
+	if (rules.Cells < 0) || (rules.Cells > 8) {
+		return fmt.Errorf("number of cells must be from 0 to 8, got %d", rules.Cells)
+	}
+	if (len(rules.DealDown) != 0) && (len(rules.DealDown) != rules.Columns) {
+		return fmt.Errorf("deal-down has %d columns instead of %d", len(rules.DealDown), rules.Columns)
+	}
+	if (len(rules.DealUp) != 0) && (len(rules.DealUp) != rules.Columns) {
+		return fmt.Errorf("deal-up has %d columns instead of %d", len(rules.DealUp), rules.Columns)
+	}
+	if rules.Reserve < 0 {
+		return fmt.Errorf("reserve must not be negative")
+	}
+	if (rules.Stock == StockWaste) && (rules.Draw < 1) {
+		return fmt.Errorf("draw must be positive when stock deals to waste")
+	}
+	if rules.Redeals < -1 {
+		return fmt.Errorf("redeals must be a number or unlimited")
+	}
+	if (rules.StockOptions) && (rules.Stock != StockWaste) {
+		return fmt.Errorf("stock options need stock which deals to waste")
+	}
+	if (rules.FoundationRuns) && ((rules.DealBase) || (rules.Base != Ace) || (rules.Wrap)) {
+		return fmt.Errorf("foundation runs always go from King to Ace")
+	}
+
+	dealt := rules.Reserve
+	if rules.DealBase {
+		dealt++
+	}
+	for i := 0; i < len(rules.DealDown); i++ {
+		dealt += rules.DealDown[i]
+	}
+	for i := 0; i < len(rules.DealUp); i++ {
+		dealt += rules.DealUp[i]
+	}
+	if dealt > rules.Decks*52 {
+		return fmt.Errorf("%d cards are dealt, but there are only %d", dealt, rules.Decks*52)
+	}
+	if (dealt < rules.Decks*52) && (rules.Stock == StockNone) && (rules.Engine == EnginePatience) {
+		return fmt.Errorf("%d cards are left after deal, but there is no stock", rules.Decks*52-dealt)
+	}
+
+	if rules.Engine == EngineFreeCell {
+		if (rules.Decks != 1) || (rules.Stock != StockNone) || (rules.Reserve != 0) || (rules.DealBase) || (rules.Base != Ace) || (rules.FoundationRuns) || (rules.Grab != GrabRun) || (rules.Direction != BuildDown) || (rules.Wrap) {
+			return fmt.Errorf("freecell engine only supports one deck, no stock and no reserve, foundations from Ace and building runs down")
+		}
+		if len(rules.DealDown)+len(rules.DealUp) != 0 {
+			return fmt.Errorf("freecell engine deals all cards face-up, one to each column in turn")
+		}
+	}
+
+	return nil
+}
+
+/* CanFillEmpty reports whether card may be put into empty column, either alone or at the bottom of a run. */
+func (rules *Rules) CanFillEmpty(card *Card) bool {
+	switch rules.Empty {
+	case EmptyKings:
+		return card.Value == King
+	case EmptyNone:
+		return false
+	}
+	return true
+}
+
+/* CanBuild reports whether src may be put on dst on the table. */
+func (rules *Rules) CanBuild(src, dst *Card) bool {
+	switch rules.Direction {
+	default:
+		return CanBuild(src, dst, rules.Build, rules.Wrap)
+	case BuildUp:
+		return CanBuild(dst, src, rules.Build, rules.Wrap)
+	case BuildBoth:
+		return (CanBuild(src, dst, rules.Build, rules.Wrap)) || (CanBuild(dst, src, rules.Build, rules.Wrap))
+	}
+}